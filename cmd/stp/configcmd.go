@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"stp/internal/config"
+	"stp/internal/secret"
+)
+
+const configUsage = `用法:
+  %[1]s config convert [-to json|yaml|toml] [-f] <输入文件> [输出文件]
+        在 JSON、YAML、TOML 之间转换配置文件，格式由扩展名（.json/.yaml/.yml/.toml）决定。
+        未指定输出文件时按 -to 指定的格式写到标准输出；-f 允许覆盖已存在的输出文件。
+        转换不展开 ${...} 引用、不解密 secret:，也不填入默认值。
+  %[1]s config show [-origin] [-full] [-config <path>]
+        显示合并 Extends/Include、PromptsDir 与 STP_* 环境变量后的实际配置，每行一项。
+        -origin 同时显示每项来自哪个文件（default 为默认值，env 为环境变量）；
+        -full 显示完整的值（默认截断过长的值）。Token、密钥与认证类请求头只显示为 ****。
+`
+
+// runConfig implements the "config" subcommand and returns the exit code.
+func runConfig(program string, args []string, stdout, stderr io.Writer) int {
+	usage := func() int {
+		fmt.Fprintf(stderr, configUsage, program)
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+	switch args[0] {
+	case "convert":
+		fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		fs.Usage = func() { usage() }
+		to := fs.String("to", "", "output format (json|yaml|toml)")
+		force := fs.Bool("f", false, "overwrite the output file")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if fs.NArg() < 1 || fs.NArg() > 2 || (fs.NArg() == 1 && *to == "") {
+			return usage()
+		}
+		out := ""
+		if fs.NArg() == 2 {
+			out = fs.Arg(1)
+		}
+		if err := convertConfig(fs.Arg(0), out, *to, *force, stdout); err != nil {
+			fmt.Fprintf(stderr, "[config] %v\n", err)
+			return 1
+		}
+		return 0
+	case "show":
+		fs := flag.NewFlagSet("show", flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		fs.Usage = func() { usage() }
+		configPath := fs.String("config", "", "config file")
+		origin := fs.Bool("origin", false, "show the file each setting comes from")
+		full := fs.Bool("full", false, "do not shorten long values")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if fs.NArg() != 0 {
+			return usage()
+		}
+		if err := showConfig(*configPath, *origin, *full, stdout); err != nil {
+			fmt.Fprintf(stderr, "[config] %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	return usage()
+}
+
+func convertConfig(in, out, to string, force bool, stdout io.Writer) error {
+	if to == "" {
+		to = config.FormatOf(out)
+	}
+	switch to {
+	case config.FormatJSON, config.FormatYAML, config.FormatTOML:
+	default:
+		return fmt.Errorf("unknown format %q (want json, yaml or toml)", to)
+	}
+	data, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	converted, err := config.Convert(data, config.FormatOf(in), to)
+	if err != nil {
+		return fmt.Errorf("%s: %w", in, err)
+	}
+	if out == "" {
+		_, err = stdout.Write(converted)
+		return err
+	}
+	if !force {
+		if _, err := os.Stat(out); err == nil {
+			return fmt.Errorf("%s exists; use -f to overwrite", out)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.WriteFile(out, converted, 0o600); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "[config] %s -> %s (%s)\n", in, out, to)
+	return nil
+}
+
+// showValueWidth is the length long values are shortened to.
+const showValueWidth = 80
+
+// envOrigin is the origin of settings overridden by STP_* variables.
+const envOrigin = "env"
+
+func showConfig(configPath string, origin, full bool, stdout io.Writer) error {
+	if configPath == "" {
+		configPath = os.Getenv("STP_CONFIG")
+	}
+	if configPath == "" {
+		configPath = config.FindDefault()
+	}
+	cfg, origins, err := config.LoadWithOrigins(configPath)
+	if err != nil {
+		return err
+	}
+	fromFiles, err := config.Settings(redact(cfg), origins)
+	if err != nil {
+		return err
+	}
+	if err := config.ApplyCLI(&cfg, config.CLIOptions{}); err != nil {
+		return err
+	}
+	settings, err := config.Settings(redact(cfg), origins)
+	if err != nil {
+		return err
+	}
+	before := map[string]string{}
+	for _, s := range fromFiles {
+		before[s.Path] = s.Value
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	for _, s := range settings {
+		value := s.Value
+		if !full && utf8.RuneCountInString(value) > showValueWidth {
+			value = string([]rune(value)[:showValueWidth-1]) + "…"
+		}
+		if !origin {
+			fmt.Fprintf(w, "%s\t%s\n", s.Path, value)
+			continue
+		}
+		if v, ok := before[s.Path]; !ok || v != s.Value {
+			s.Origin = envOrigin
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Path, value, s.Origin)
+	}
+	return w.Flush()
+}
+
+// sensitiveHeader matches the names of headers whose values are shown
+// redacted.
+var sensitiveHeader = regexp.MustCompile(`(?i)auth|key|token|secret|cookie|session`)
+
+// redact returns cfg with tokens, client secrets, AWS credentials and
+// credential headers masked. "secret:" references are kept since they are
+// not secret themselves.
+func redact(cfg config.Config) config.Config {
+	mask := func(s string) string {
+		if s == "" || strings.HasPrefix(s, secret.Prefix) {
+			return s
+		}
+		return "****"
+	}
+	maskTokens := func(t config.TokenList) config.TokenList {
+		keys := t.Keys()
+		for i := range keys {
+			keys[i] = mask(keys[i])
+		}
+		return config.NewTokenList(keys...)
+	}
+	maskHeaders := func(h map[string]string) map[string]string {
+		if h == nil {
+			return nil
+		}
+		out := make(map[string]string, len(h))
+		for k, v := range h {
+			if sensitiveHeader.MatchString(k) {
+				v = mask(v)
+			}
+			out[k] = v
+		}
+		return out
+	}
+	maskEndpoints := func(eps []config.EndpointConfig) []config.EndpointConfig {
+		out := append([]config.EndpointConfig(nil), eps...)
+		for i := range out {
+			out[i].Token = mask(out[i].Token)
+		}
+		return out
+	}
+	maskOAuth2 := func(o *config.OAuth2Config) *config.OAuth2Config {
+		if o == nil {
+			return nil
+		}
+		c := *o
+		c.ClientSecret = mask(c.ClientSecret)
+		return &c
+	}
+	maskSigV4 := func(s *config.SigV4Config) *config.SigV4Config {
+		if s == nil {
+			return nil
+		}
+		c := *s
+		c.SecretAccessKey = mask(c.SecretAccessKey)
+		c.SessionToken = mask(c.SessionToken)
+		return &c
+	}
+
+	cfg.Token = maskTokens(cfg.Token)
+	cfg.Headers = maskHeaders(cfg.Headers)
+	cfg.Endpoints = maskEndpoints(cfg.Endpoints)
+	cfg.OAuth2 = maskOAuth2(cfg.OAuth2)
+	cfg.SigV4 = maskSigV4(cfg.SigV4)
+	notifiers := append([]config.NotifierConfig(nil), cfg.Notifiers...)
+	for i := range notifiers {
+		notifiers[i].Headers = maskHeaders(notifiers[i].Headers)
+	}
+	cfg.Notifiers = notifiers
+	entries := append([]config.HotKeyEntry(nil), cfg.HotKeyConfig...)
+	for i := range entries {
+		e := &entries[i]
+		e.Token = maskTokens(e.Token)
+		e.Headers = maskHeaders(e.Headers)
+		e.Endpoints = maskEndpoints(e.Endpoints)
+		e.OAuth2 = maskOAuth2(e.OAuth2)
+		e.SigV4 = maskSigV4(e.SigV4)
+		fallbacks := append([]config.FallbackConfig(nil), e.Fallbacks...)
+		for j := range fallbacks {
+			fallbacks[j].Token = mask(fallbacks[j].Token)
+		}
+		e.Fallbacks = fallbacks
+		if e.FanOut != nil {
+			fo := *e.FanOut
+			fo.Targets = append([]config.FanOutTarget(nil), fo.Targets...)
+			for j := range fo.Targets {
+				fo.Targets[j].Token = mask(fo.Targets[j].Token)
+			}
+			e.FanOut = &fo
+		}
+	}
+	cfg.HotKeyConfig = entries
+	return cfg
+}
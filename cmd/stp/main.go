@@ -5,7 +5,6 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
-	"strings"
 	"syscall"
 	"time"
 
@@ -19,6 +18,14 @@ import (
 
 func main() {
 	program := filepath.Base(os.Args[0])
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "secret":
+			os.Exit(runSecret(program, os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+		case "config":
+			os.Exit(runConfig(program, os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
 	opts, err := config.ParseCLI(os.Args[1:], os.Stderr)
 	if err != nil {
 		os.Exit(2)
@@ -33,9 +40,16 @@ func main() {
 		fmt.Printf("[main] %v\n", err)
 		os.Exit(1)
 	}
-	config.ApplyCLI(&cfg, opts)
+	if err := config.ApplyCLI(&cfg, opts); err != nil {
+		fmt.Printf("[main] %v\n", err)
+		os.Exit(2)
+	}
 
-	httpClient, transport := netclient.New(cfg)
+	httpClient, transport, err := netclient.New(cfg)
+	if err != nil {
+		fmt.Printf("[main] %v\n", err)
+		os.Exit(1)
+	}
 	defer transport.CloseIdleConnections()
 
 	textIO := &clipboard.Manager{
@@ -51,37 +65,38 @@ func main() {
 		os.Exit(1)
 	}
 	application.Start()
-	defer application.Close()
+	live := &liveApp{app: application}
+	defer live.close()
 
-	taskSpecs := map[int]string{}
-	for i, entry := range cfg.HotKeyConfig {
-		if strings.TrimSpace(entry.Prompt) != "" && strings.TrimSpace(entry.HotKey) != "" {
-			taskSpecs[i+1] = entry.HotKey
-		}
-	}
-	if len(taskSpecs) == 0 {
+	hotkeyOpts := hotkeyOptions(cfg)
+	if len(hotkeyOpts.TaskHotkeys) == 0 {
 		fmt.Println("[main] no prompts configured; nothing to register. Exiting.")
 		return
 	}
 
-	hotkeyService := hotkey.NewService(hotkey.Options{
-		UseHook:        cfg.HotKeyHook,
-		TaskHotkeys:    taskSpecs,
-		StopTaskHotkey: cfg.StopTaskHotkey,
-		Debug:          cfg.DEBUG,
-	})
-	if err := hotkeyService.Start(func(ev hotkey.Event) {
+	handler := func(ev hotkey.Event) {
 		switch ev.Type {
 		case hotkey.StopEvent:
-			application.StopAll()
+			live.stopAll()
+			// Show why queued requests may have been waiting.
+			live.get().LogRateLimitStatus()
 		case hotkey.TaskEvent:
-			application.EnqueueTask(ev.TaskID)
+			live.get().EnqueueTask(ev.TaskID)
 		}
-	}); err != nil {
+	}
+	if err := live.startHotkeys(hotkeyOpts, handler); err != nil {
 		fmt.Printf("[main] failed to start hotkey service: %v\n", err)
 		os.Exit(1)
 	}
-	defer hotkeyService.Close()
+
+	if cfg.PromptsDir != "" {
+		stop := make(chan struct{})
+		defer close(stop)
+		go watchPrompts(cfg.PromptsDir, stop, func() {
+			live.reload(opts, httpClient, textIO, handler)
+		})
+		fmt.Printf("[prompts] watching %s for changes\n", cfg.PromptsDir)
+	}
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
@@ -91,12 +106,16 @@ func main() {
 }
 
 func loadConfigWithFallback(opts config.CLIOptions) (config.Config, error) {
+	if opts.ConfigPath == "" {
+		opts.ConfigPath = os.Getenv("STP_CONFIG")
+	}
 	if opts.ConfigPath != "" {
 		return config.Load(opts.ConfigPath)
 	}
-	if _, err := os.Stat("config.json"); err == nil {
-		return config.Load("config.json")
-	} else if os.IsNotExist(err) {
+	if path := config.FindDefault(); path != "" {
+		return config.Load(path)
+	}
+	if _, err := os.Stat("config.json"); os.IsNotExist(err) {
 		if !opts.AnyOverrideSet() {
 			if err := config.SaveDefault("config.json"); err != nil {
 				return config.Config{}, fmt.Errorf("failed create default config: %w", err)
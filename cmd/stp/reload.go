@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"stp/internal/app"
+	"stp/internal/clipboard"
+	"stp/internal/config"
+	"stp/internal/hotkey"
+	"stp/internal/netclient"
+)
+
+// promptsPollInterval is how often PromptsDir is checked for changes.
+const promptsPollInterval = time.Second
+
+// hotkeyOptions returns the hotkey service options for cfg; task IDs are
+// 1-based HotKeyConfig indexes.
+func hotkeyOptions(cfg config.Config) hotkey.Options {
+	taskSpecs := map[int]string{}
+	for i, entry := range cfg.HotKeyConfig {
+		if strings.TrimSpace(entry.Prompt) != "" && strings.TrimSpace(entry.HotKey) != "" {
+			taskSpecs[i+1] = entry.HotKey
+		}
+	}
+	return hotkey.Options{
+		UseHook:        cfg.HotKeyHook,
+		TaskHotkeys:    taskSpecs,
+		StopTaskHotkey: cfg.StopTaskHotkey,
+		Debug:          cfg.DEBUG,
+	}
+}
+
+// liveApp holds the running App and hotkey service, which are replaced
+// when the prompt files change. Replaced Apps drain in the background.
+type liveApp struct {
+	// reloadMu serializes reload and close; mu guards the fields read by
+	// the hotkey handler.
+	reloadMu sync.Mutex
+
+	mu       sync.Mutex
+	app      *app.App
+	draining []*app.App
+	closed   bool
+
+	hotkeys    hotkey.Service
+	hotkeyOpts hotkey.Options
+}
+
+func (l *liveApp) get() *app.App {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.app
+}
+
+// stopAll cancels the tasks of the current App and of Apps still draining.
+func (l *liveApp) stopAll() {
+	l.mu.Lock()
+	apps := append([]*app.App{l.app}, l.draining...)
+	l.mu.Unlock()
+	for _, a := range apps {
+		a.StopAll()
+	}
+}
+
+func (l *liveApp) startHotkeys(opts hotkey.Options, handler func(hotkey.Event)) error {
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+	svc := hotkey.NewService(opts)
+	if err := svc.Start(handler); err != nil {
+		return err
+	}
+	l.hotkeys, l.hotkeyOpts = svc, opts
+	return nil
+}
+
+func (l *liveApp) close() {
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+	if l.hotkeys != nil {
+		l.hotkeys.Close()
+	}
+	l.mu.Lock()
+	l.closed = true
+	apps := append([]*app.App{l.app}, l.draining...)
+	l.mu.Unlock()
+	for _, a := range apps {
+		a.Close()
+	}
+}
+
+// reload loads the configuration again and swaps in a new App. Hotkeys
+// are only registered again when they changed. The previous App finishes
+// its running and queued tasks before it closes. On any error the previous
+// App and hotkeys stay in place.
+func (l *liveApp) reload(opts config.CLIOptions, doer netclient.Doer, textIO clipboard.TextIO, handler func(hotkey.Event)) {
+	cfg, err := loadConfigWithFallback(opts)
+	if err == nil {
+		err = config.ApplyCLI(&cfg, opts)
+	}
+	var next *app.App
+	if err == nil {
+		next, err = app.New(cfg, doer, textIO)
+	}
+	if err != nil {
+		fmt.Printf("[prompts] reload failed, keeping the previous prompts: %v\n", err)
+		return
+	}
+
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+	if l.closed {
+		return
+	}
+	if hotkeyOpts := hotkeyOptions(cfg); !reflect.DeepEqual(hotkeyOpts, l.hotkeyOpts) {
+		if err := l.hotkeys.Close(); err != nil {
+			fmt.Printf("[prompts] failed to stop hotkey service: %v\n", err)
+		}
+		svc := hotkey.NewService(hotkeyOpts)
+		if err := svc.Start(handler); err != nil {
+			fmt.Printf("[prompts] reload failed, keeping the previous prompts: %v\n", err)
+			// Take the previous hotkeys back.
+			svc = hotkey.NewService(l.hotkeyOpts)
+			if err := svc.Start(handler); err != nil {
+				fmt.Printf("[prompts] failed to restore hotkeys: %v\n", err)
+			}
+			l.hotkeys = svc
+			return
+		}
+		l.hotkeys, l.hotkeyOpts = svc, hotkeyOpts
+	}
+	next.Start()
+
+	l.mu.Lock()
+	prev := l.app
+	l.app = next
+	draining := l.draining[:0]
+	for _, a := range l.draining {
+		if !a.Closed() {
+			draining = append(draining, a)
+		}
+	}
+	l.draining = append(draining, prev)
+	l.mu.Unlock()
+	prev.Drain()
+	fmt.Printf("[prompts] reloaded; %d hotkeys registered\n", len(l.hotkeyOpts.TaskHotkeys))
+}
+
+// watchPrompts calls reload when the prompt files under dir change, until
+// stop is closed. A change is only acted upon once the files have been
+// stable for one poll interval, so that half-written files are not loaded.
+func watchPrompts(dir string, stop <-chan struct{}, reload func()) {
+	last, _ := config.PromptsState(dir)
+	pending := last
+	ticker := time.NewTicker(promptsPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		state, err := config.PromptsState(dir)
+		if err != nil || state == last {
+			pending = last
+			continue
+		}
+		if state != pending {
+			pending = state
+			continue
+		}
+		last = state
+		reload()
+	}
+}
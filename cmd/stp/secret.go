@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"stp/internal/config"
+	"stp/internal/secret"
+)
+
+const secretUsage = `用法:
+  %[1]s secret set <name>    从标准输入读取一行作为 secret 的值并加密保存
+  %[1]s secret list          列出所有 secret 的名称（不显示值）
+  %[1]s secret rm <name>     删除 secret
+
+选项（写在子命令之前，例如 %[1]s secret -config my.json set openai）:
+  -config <path>           配置文件路径，读取其中的 SecretsFile/SecretKeyFile（默认 config.json/yaml/yml/toml）
+  -secrets-file <path>     加密文件路径（默认配置文件同目录下的 secrets.json），也可用环境变量 STP_SECRETS_FILE 设置
+  -secret-key-file <path>  本机密钥文件路径，也可用环境变量 STP_SECRET_KEY_FILE 设置
+
+配置文件中只读取 SecretsFile 与 SecretKeyFile，其余设置中的引用错误不影响 secret 子命令。
+
+设置环境变量 %[2]s 时使用口令派生密钥（PBKDF2-SHA256），否则在用户配置目录生成本机密钥文件。
+配置中以 "secret:<name>" 引用，例如 "Token": "secret:openai"。
+`
+
+// runSecret implements the "secret" subcommand and returns the exit code.
+func runSecret(program string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("secret", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprintf(stderr, secretUsage, program, secret.PassphraseEnv) }
+	configPath := fs.String("config", "", "config file")
+	secretsFile := fs.String("secrets-file", "", "encrypted secrets file")
+	keyFile := fs.String("secret-key-file", "", "machine-local key file")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	rest := fs.Args()
+	if len(rest) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	store, err := openSecretStore(*configPath, *secretsFile, *keyFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "[secret] %v\n", err)
+		return 1
+	}
+	switch cmd := rest[0]; {
+	case cmd == "list" && len(rest) == 1:
+		for _, name := range store.Names() {
+			fmt.Fprintln(stdout, name)
+		}
+	case cmd == "set" && len(rest) == 2:
+		value, err := readSecretValue(stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "[secret] %v\n", err)
+			return 1
+		}
+		if err := store.Set(rest[1], value); err != nil {
+			fmt.Fprintf(stderr, "[secret] %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "[secret] %s saved to %s; reference it as \"%s%s\"\n", rest[1], store.Path(), secret.Prefix, rest[1])
+	case (cmd == "rm" || cmd == "remove") && len(rest) == 2:
+		ok, err := store.Remove(rest[1])
+		if err != nil {
+			fmt.Fprintf(stderr, "[secret] %v\n", err)
+			return 1
+		}
+		if !ok {
+			fmt.Fprintf(stderr, "[secret] %s not found\n", rest[1])
+			return 1
+		}
+		fmt.Fprintf(stdout, "[secret] %s removed\n", rest[1])
+	default:
+		fs.Usage()
+		return 2
+	}
+	return 0
+}
+
+// openSecretStore locates the secrets file like the app does: the flag,
+// else STP_SECRETS_FILE, else the SecretsFile of the config file (see
+// config.FindDefault), else secrets.json in the working directory. The key
+// file is found the same way. Only these two settings are read from the
+// config file, so that errors elsewhere in it do not get in the way.
+func openSecretStore(configPath, secretsFile, keyFile string) (*secret.Store, error) {
+	cfg := config.Default()
+	if configPath == "" {
+		configPath = os.Getenv("STP_CONFIG")
+	}
+	if configPath == "" {
+		configPath = config.FindDefault()
+	}
+	if configPath != "" {
+		var err error
+		if cfg.SecretsFile, cfg.SecretKeyFile, err = config.LoadSecretPaths(configPath); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := os.LookupEnv(config.EnvName("secrets-file")); ok {
+		cfg.SecretsFile = v
+	}
+	if v, ok := os.LookupEnv(config.EnvName("secret-key-file")); ok {
+		cfg.SecretKeyFile = v
+	}
+	if secretsFile != "" {
+		cfg.SecretsFile = secretsFile
+	}
+	if keyFile != "" {
+		cfg.SecretKeyFile = keyFile
+	}
+	return secret.Open(cfg.SecretsFile, secret.Options{
+		Passphrase: os.Getenv(secret.PassphraseEnv),
+		KeyFile:    cfg.SecretKeyFile,
+	})
+}
+
+// readSecretValue reads the first line of r so that the value never appears
+// in the shell history or process list.
+func readSecretValue(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", errors.New("empty value; pipe the secret on standard input")
+	}
+	return line, nil
+}
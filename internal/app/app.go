@@ -2,7 +2,9 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -19,32 +21,239 @@ type App struct {
 	httpDoer    netclient.Doer
 	textIO      clipboard.TextIO
 	globalExtra map[string]interface{}
+	globalPatch []request.PatchOp
+	retry       *netclient.RetryPolicy
+	secrets     *secretResolver
+	// limiter and breaker are shared by all entries; nil when disabled.
+	limiter *netclient.RateLimiter
+	breaker *netclient.CircuitBreaker
+
+	globalHeaders headerSet
+	entryHeaders  []headerSet
+	// entryTokens holds the OAuth2 token source of each entry; entries
+	// without their own OAuth2 settings share the global source.
+	entryTokens []netclient.TokenSource
+	// entryKeys holds the key ring of each entry with several API keys;
+	// entries without a Token of their own share the global ring.
+	entryKeys []*netclient.KeyRing
+	// entrySigners holds the SigV4 signer of entries using AuthScheme sigv4.
+	entrySigners []netclient.RequestSigner
+	// entryPools holds the endpoint pool of each entry (nil for a single
+	// endpoint); pools lists each distinct pool once for health probing.
+	globalPool *netclient.EndpointPool
+	entryPools []*netclient.EndpointPool
+	pools      []probedPool
+	// entryFallbacks and entryFanOuts hold the compiled fallback chain and
+	// fan-out (nil when unset) of each entry.
+	entryFallbacks [][]fallback
+	entryFanOuts   []*fanOut
+
+	failureRules map[string]failureRule
+	notifiers    []*filteredNotifier
+	notifyWG     sync.WaitGroup
 
 	eventCh chan int
 	stopCh  chan struct{}
+	// drainCh is closed by Drain.
+	drainCh chan struct{}
 
 	mu            sync.Mutex
 	currentCancel context.CancelFunc
 	closed        bool
+	draining      bool
 	wg            sync.WaitGroup
 }
 
 func New(cfg config.Config, httpDoer netclient.Doer, textIO clipboard.TextIO) (*App, error) {
-	globalExtra, err := request.ParseExtraConfig(cfg.ExtraConfig)
+	globalExtra, err := request.ParseExtraConfig(string(cfg.ExtraConfig))
 	if err != nil {
 		return nil, fmt.Errorf("invalid ExtraConfig JSON: %w", err)
 	}
+	globalPatch, err := request.ParseJSONPatch(string(cfg.ExtraPatch))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ExtraPatch JSON: %w", err)
+	}
+	if err := netclient.ValidateProxy(netclient.ProxySettings{Proxy: cfg.Proxy, NoProxy: cfg.NoProxy}); err != nil {
+		return nil, fmt.Errorf("invalid Proxy: %w", err)
+	}
+	if err := netclient.ValidateAuth(cfg.AuthScheme, cfg.AuthParam); err != nil {
+		return nil, fmt.Errorf("invalid AuthScheme: %w", err)
+	}
+	if err := request.ValidateFormat(cfg.RequestFormat); err != nil {
+		return nil, err
+	}
+	globalHeaders, err := compileHeaders(cfg.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Headers: %w", err)
+	}
+	secrets := &secretResolver{path: cfg.SecretsFile, keyFile: cfg.SecretKeyFile}
+	var globalTokens netclient.TokenSource
+	if cfg.OAuth2 != nil {
+		ts, err := newOAuth2(*cfg.OAuth2, httpDoer, secrets)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OAuth2: %w", err)
+		}
+		globalTokens = ts
+	}
+	if err := netclient.ValidateTokenRotation(cfg.TokenRotation); err != nil {
+		return nil, err
+	}
+	globalKeys, err := newKeyRing(cfg, cfg.Token.Keys())
+	if err != nil {
+		return nil, fmt.Errorf("invalid Token: %w", err)
+	}
+	entryHeaders := make([]headerSet, len(cfg.HotKeyConfig))
+	entryKeys := make([]*netclient.KeyRing, len(cfg.HotKeyConfig))
+	entryTokens := make([]netclient.TokenSource, len(cfg.HotKeyConfig))
+	entrySigners := make([]netclient.RequestSigner, len(cfg.HotKeyConfig))
+	var pools []probedPool
+	globalPool, err := newPool(cfg, cfg.Endpoints, cfg.EndpointStrategy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Endpoints: %w", err)
+	}
+	if globalPool != nil {
+		pools = append(pools, probedPool{globalPool, netclient.ProxySettings{Proxy: cfg.Proxy, NoProxy: cfg.NoProxy}})
+	}
+	entryPools := make([]*netclient.EndpointPool, len(cfg.HotKeyConfig))
+	entryFallbacks := make([][]fallback, len(cfg.HotKeyConfig))
+	entryFanOuts := make([]*fanOut, len(cfg.HotKeyConfig))
+	for i, e := range cfg.HotKeyConfig {
+		if err := netclient.ValidateProxy(netclient.ProxySettings{Proxy: e.Proxy, NoProxy: e.NoProxy}); err != nil {
+			return nil, fmt.Errorf("invalid Proxy in HotKeyConfig[%d]: %w", i, err)
+		}
+		if err := netclient.ValidateAuth(e.AuthScheme, e.AuthParam); err != nil {
+			return nil, fmt.Errorf("invalid AuthScheme in HotKeyConfig[%d]: %w", i, err)
+		}
+		if entryHeaders[i], err = compileHeaders(e.Headers); err != nil {
+			return nil, fmt.Errorf("invalid Headers in HotKeyConfig[%d]: %w", i, err)
+		}
+		entryTokens[i] = globalTokens
+		if e.OAuth2 != nil {
+			ts, err := newOAuth2(*e.OAuth2, httpDoer, secrets)
+			if err != nil {
+				return nil, fmt.Errorf("invalid OAuth2 in HotKeyConfig[%d]: %w", i, err)
+			}
+			entryTokens[i] = ts
+		}
+		entryKeys[i] = globalKeys
+		if keys := e.Token.Keys(); len(keys) > 0 {
+			if entryKeys[i], err = newKeyRing(cfg, keys); err != nil {
+				return nil, fmt.Errorf("invalid Token in HotKeyConfig[%d]: %w", i, err)
+			}
+		}
+		settings := cfg.EntrySettings(e)
+		entryPools[i] = globalPool
+		if len(e.Endpoints) > 0 {
+			strategy := e.EndpointStrategy
+			if strings.TrimSpace(strategy) == "" {
+				strategy = cfg.EndpointStrategy
+			}
+			if entryPools[i], err = newPool(cfg, e.Endpoints, strategy); err != nil {
+				return nil, fmt.Errorf("invalid Endpoints in HotKeyConfig[%d]: %w", i, err)
+			}
+			pools = append(pools, probedPool{entryPools[i], netclient.ProxySettings{Proxy: settings.Proxy, NoProxy: settings.NoProxy}})
+		}
+		if err := request.ValidateFormat(settings.RequestFormat); err != nil {
+			return nil, fmt.Errorf("invalid RequestFormat in HotKeyConfig[%d]: %w", i, err)
+		}
+		if strings.EqualFold(settings.AuthScheme, netclient.AuthSigV4) {
+			sc := config.SigV4Config{}
+			if e.SigV4 != nil {
+				sc = *e.SigV4
+			} else if cfg.SigV4 != nil {
+				sc = *cfg.SigV4
+			}
+			signer, err := netclient.NewSigV4Signer(sc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SigV4 in HotKeyConfig[%d]: %w", i, err)
+			}
+			entrySigners[i] = signer
+		}
+		if entryFallbacks[i], err = compileFallbacks(e.Fallbacks); err != nil {
+			return nil, fmt.Errorf("invalid Fallbacks in HotKeyConfig[%d]: %w", i, err)
+		}
+		if entryFanOuts[i], err = compileFanOut(e.FanOut); err != nil {
+			return nil, fmt.Errorf("invalid FanOut in HotKeyConfig[%d]: %w", i, err)
+		}
+		if entrySigners[i] != nil {
+			if err := checkSignedOverrides(entryFallbacks[i], entryFanOuts[i]); err != nil {
+				return nil, fmt.Errorf("invalid HotKeyConfig[%d]: %w", i, err)
+			}
+		}
+	}
+	limiter := netclient.NewRateLimiter(cfg)
+	if limiter != nil && cfg.DEBUG {
+		limiter.Logf = func(format string, args ...interface{}) {
+			fmt.Printf(format+"\n", args...)
+		}
+	}
+	failureRules, err := compileFailureRules(cfg.FailureNotifications)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FailureNotifications: %w", err)
+	}
+	notifiers, err := newNotifiers(cfg.Notifiers, httpDoer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Notifiers: %w", err)
+	}
 	return &App{
 		cfg:         cfg,
 		httpDoer:    httpDoer,
 		textIO:      textIO,
 		globalExtra: globalExtra,
-		eventCh:     make(chan int, 64),
-		stopCh:      make(chan struct{}),
+		globalPatch: globalPatch,
+		retry:       netclient.NewRetryPolicy(cfg),
+		secrets:     secrets,
+		limiter:     limiter,
+		breaker:     netclient.NewCircuitBreaker(cfg),
+
+		globalHeaders: globalHeaders,
+		entryHeaders:  entryHeaders,
+		entryTokens:   entryTokens,
+		entryKeys:     entryKeys,
+		entrySigners:  entrySigners,
+		globalPool:    globalPool,
+		entryPools:    entryPools,
+		pools:         pools,
+
+		entryFallbacks: entryFallbacks,
+		entryFanOuts:   entryFanOuts,
+
+		failureRules: failureRules,
+		notifiers:    notifiers,
+		eventCh:      make(chan int, 64),
+		stopCh:       make(chan struct{}),
+		drainCh:      make(chan struct{}),
 	}, nil
 }
 
+// AddNotifier registers an extra notifier for the given event types (all
+// types when none are given). It must be called before Start.
+func (a *App) AddNotifier(n Notifier, events ...EventType) {
+	fn := newFilteredNotifier(n)
+	for _, e := range events {
+		fn.events[e] = true
+	}
+	a.notifiers = append(a.notifiers, fn)
+}
+
 func (a *App) Start() {
+	if d := a.cfg.HealthCheckInterval; d > 0 && len(a.pools) > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			<-a.stopCh
+			cancel()
+		}()
+		for _, p := range a.pools {
+			a.wg.Add(1)
+			go func(p probedPool) {
+				defer a.wg.Done()
+				// Probes go through the proxy the pool's requests use.
+				p.pool.StartProbing(netclient.WithProxy(ctx, p.proxy), a.httpDoer, time.Duration(d*float64(time.Second)))
+			}(p)
+		}
+	}
 	a.wg.Add(1)
 	go func() {
 		defer a.wg.Done()
@@ -53,12 +262,54 @@ func (a *App) Start() {
 			case <-a.stopCh:
 				return
 			case id := <-a.eventCh:
-				a.handleTask(id)
+				a.runTask(id)
+			case <-a.drainCh:
+				a.drainQueue()
+				go a.Close()
+				return
 			}
 		}
 	}()
 }
 
+// Drain stops accepting tasks and closes the App in the background once
+// the running and queued tasks have finished, so that a replacement App can
+// take over without canceling work in flight.
+func (a *App) Drain() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed || a.draining {
+		return
+	}
+	a.draining = true
+	close(a.drainCh)
+}
+
+// drainQueue runs the queued tasks until the queue is empty or the App is
+// closed.
+func (a *App) drainQueue() {
+	for {
+		select {
+		case <-a.stopCh:
+			return
+		default:
+		}
+		select {
+		case id := <-a.eventCh:
+			a.runTask(id)
+		default:
+			return
+		}
+	}
+}
+
+// Closed reports whether Close has been called, e.g. after Drain finished.
+func (a *App) Closed() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.closed
+}
+
 func (a *App) Close() {
 	a.mu.Lock()
 	if a.closed {
@@ -73,11 +324,15 @@ func (a *App) Close() {
 	}
 	a.mu.Unlock()
 	a.wg.Wait()
+	a.notifyWG.Wait()
+	for _, n := range a.notifiers {
+		n.stop()
+	}
 }
 
 func (a *App) EnqueueTask(id int) {
 	a.mu.Lock()
-	closed := a.closed
+	closed := a.closed || a.draining
 	a.mu.Unlock()
 	if closed {
 		return
@@ -119,25 +374,81 @@ func (a *App) clearCurrentCancel(cancel context.CancelFunc) {
 	a.currentCancel = nil
 }
 
-func (a *App) handleTask(id int) {
-	if id < 1 || id > len(a.cfg.HotKeyConfig) {
-		return
+// handleTask runs one hotkey task. It returns nil for skipped or successful
+// tasks, and otherwise one of the netclient errors, *ExtractionError or
+// *ClipboardError.
+func (a *App) handleTask(id int) error {
+	entry, ok := a.taskEntry(id)
+	if !ok {
+		return nil
 	}
-	entry := a.cfg.HotKeyConfig[id-1]
-	prompt := strings.TrimSpace(entry.Prompt)
-	if prompt == "" {
-		return
+
+	// The task deadline covers copying the selection, every retry and the paste.
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if d := a.cfg.EntrySettings(entry).TaskTimeout; d > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(d*float64(time.Second)))
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
 	}
+	a.setCurrentCancel(cancel)
+	defer func() {
+		cancel()
+		a.clearCurrentCancel(cancel)
+	}()
 
 	selectedText, err := a.textIO.CopySelected()
-	if err != nil || strings.TrimSpace(selectedText) == "" {
-		if a.cfg.DEBUG && err != nil {
-			fmt.Printf("[copy] failed: %v\n", err)
+	if ctx.Err() != nil {
+		return netclient.ContextError(ctx, 0)
+	}
+	if err != nil {
+		return &ClipboardError{Op: "copy", Err: err}
+	}
+	if strings.TrimSpace(selectedText) == "" {
+		return &ClipboardError{Op: "copy", Err: errEmptySelection}
+	}
+
+	var extracted string
+	if fo := a.entryFanOuts[id-1]; fo != nil {
+		extracted, err = a.runFanOut(ctx, id, entry, fo, selectedText)
+	} else {
+		extracted, err = a.complete(ctx, id, entry, nil, selectedText)
+	}
+	for i, fb := range a.entryFallbacks[id-1] {
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		if !fb.matches(err) {
+			continue
+		}
+		fbEntry := fb.apply(entry)
+		model := a.cfg.EntrySettings(fbEntry).Model
+		if a.cfg.DEBUG {
+			fmt.Printf("[fallback] task id=%d entry=%s: %v; trying fallback %d (model=%s)\n", id, a.entryName(id), err, i, model)
+		}
+		extracted, err = a.complete(ctx, id, fbEntry, &a.entryFallbacks[id-1][i].override, selectedText)
+		if err == nil && a.cfg.DEBUG {
+			fmt.Printf("[fallback] task id=%d entry=%s answered by fallback %d (model=%s)\n", id, a.entryName(id), i, model)
 		}
-		return
 	}
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return netclient.ContextError(ctx, 0)
+	}
+	if err := a.textIO.PasteText(extracted); err != nil {
+		return &ClipboardError{Op: "paste", Err: err}
+	}
+	return nil
+}
 
-	perExtra, err := request.ParseExtraConfig(entry.ExtraConfig)
+// complete sends the request of entry (already merged with ov, the override
+// of a fallback step or fan-out target, if any) for selectedText and returns
+// the extracted text.
+func (a *App) complete(ctx context.Context, id int, entry config.HotKeyEntry, ov *override, selectedText string) (string, error) {
+	prompt := strings.TrimSpace(entry.Prompt)
+	perExtra, err := request.ParseExtraConfig(string(entry.ExtraConfig))
 	if err != nil {
 		if a.cfg.DEBUG {
 			fmt.Printf("[request] invalid entry ExtraConfig id=%d: %v\n", id, err)
@@ -145,58 +456,269 @@ func (a *App) handleTask(id int) {
 		perExtra = nil
 	}
 	runtimeOverrides, perExtraClean := request.ExtractRuntimeOverrides(perExtra)
+	// Typed entry fields take precedence over the legacy ExtraConfig keys.
+	if strings.TrimSpace(entry.APIEndpoint) == "" {
+		entry.APIEndpoint = runtimeOverrides.APIEndpoint
+	}
+	keys := a.entryKeys[id-1]
+	if entry.Token.First() == "" && strings.TrimSpace(runtimeOverrides.Token) != "" {
+		entry.Token = config.TokenList(runtimeOverrides.Token)
+		keys = nil
+	}
+	if strings.TrimSpace(entry.TEXTPath) == "" {
+		entry.TEXTPath = runtimeOverrides.TEXTPath
+	}
+	settings := a.cfg.EntrySettings(entry)
+	extra := request.MergeExtra(a.globalExtra, perExtraClean)
+	if ov != nil && ov.extra != nil {
+		extra = request.MergeExtra(extra, ov.extra)
+	}
 
 	payload := request.BuildPayload(request.BuildInput{
-		Model:       a.cfg.Model,
-		Temperature: a.cfg.Temperature,
-		MaxTokens:   a.cfg.MaxTokens,
+		Model:       settings.Model,
+		Temperature: settings.Temperature,
+		MaxTokens:   settings.MaxTokens,
 		Prompt:      prompt,
 		UserText:    selectedText,
-		Extra:       request.MergeExtra(a.globalExtra, perExtraClean),
+		SystemRole:  settings.SystemRole,
+		Examples:    entry.Examples,
+		Extra:       extra,
+		Profiles:    a.cfg.ModelProfiles,
+		Format:      settings.RequestFormat,
 	})
+	perPatch, err := request.ParseJSONPatch(string(entry.ExtraPatch))
+	if err != nil && a.cfg.DEBUG {
+		fmt.Printf("[request] invalid entry ExtraPatch id=%d: %v\n", id, err)
+	}
+	if ops := append(append([]request.PatchOp{}, a.globalPatch...), perPatch...); len(ops) > 0 {
+		patched, err := request.ApplyJSONPatch(payload, ops)
+		if err != nil && a.cfg.DEBUG {
+			fmt.Printf("[request] ExtraPatch not applied id=%d: %v\n", id, err)
+		}
+		payload = patched
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	a.setCurrentCancel(cancel)
-	defer func() {
-		cancel()
-		a.clearCurrentCancel(cancel)
-	}()
+	headers, err := renderHeaders(a.globalHeaders, a.entryHeaders[id-1], headerData{
+		TaskID:   id,
+		Entry:    a.entryName(id),
+		Model:    settings.Model,
+		Endpoint: settings.APIEndpoint,
+	})
+	if err != nil {
+		return "", fmt.Errorf("render headers: %w", err)
+	}
+	if err := a.secrets.resolveHeaders(headers); err != nil {
+		return "", fmt.Errorf("render headers: %w", err)
+	}
+	ctx = netclient.WithProxy(ctx, netclient.ProxySettings{Proxy: settings.Proxy, NoProxy: settings.NoProxy})
 
-	endpoint := strings.TrimSpace(runtimeOverrides.APIEndpoint)
-	if endpoint == "" {
-		endpoint = strings.TrimSpace(a.cfg.APIEndpoint)
+	// An entry with its own APIEndpoint does not use the global pool, and an
+	// override with its own APIEndpoint or Token uses neither the entry's
+	// pool nor its token source and key ring.
+	pool := a.entryPools[id-1]
+	if pool == a.globalPool && strings.TrimSpace(entry.APIEndpoint) != "" {
+		pool = nil
 	}
-	token := strings.TrimSpace(runtimeOverrides.Token)
-	if token == "" {
-		token = strings.TrimSpace(a.cfg.Token)
+	tokens := a.entryTokens[id-1]
+	if ov != nil {
+		if strings.TrimSpace(ov.APIEndpoint) != "" {
+			pool = nil
+		}
+		if strings.TrimSpace(ov.Token) != "" {
+			tokens, keys = nil, nil
+		}
+	}
+	signer := a.entrySigners[id-1]
+	if ov != nil && !ov.keepsSigner() {
+		signer = nil
+	}
+	resBody, err := netclient.SendWithRetry(ctx, a.httpDoer, settings.APIEndpoint, settings.Token, payload, netclient.RetryOptions{
+		MaxRetry:       settings.MaxRetry,
+		BaseDelay:      time.Duration(a.cfg.RetryBaseDelay * float64(time.Second)),
+		AttemptTimeout: time.Duration(settings.RequestTimeout) * time.Second,
+		ConnectTimeout: time.Duration(settings.ConnectTimeout * float64(time.Second)),
+		HeaderTimeout:  time.Duration(settings.ResponseHeaderTimeout * float64(time.Second)),
+		IdleTimeout:    time.Duration(settings.StreamIdleTimeout * float64(time.Second)),
+		Policy:         a.retry,
+		Debug:          a.cfg.DEBUG,
+		UserAgent:      settings.UserAgent,
+		Headers:        headers,
+		Auth:           settings.AuthScheme,
+		AuthParam:      settings.AuthParam,
+		TokenSource:    tokens,
+		Signer:         signer,
+		Pool:           pool,
+		Limiter:        a.limiter,
+		Breaker:        a.breaker,
+		Keys:           keys,
+		ResolveToken:   a.secrets.resolve,
+		// "{model}" lets endpoints that carry the model in the path
+		// (Bedrock, Azure deployments) follow the entry's Model.
+		MapURL: func(u string) string {
+			return strings.ReplaceAll(u, "{model}", escapeModelID(settings.Model))
+		},
+	})
+	a.LogRateLimitStatus()
+	if err != nil {
+		return "", err
 	}
 
-	resBody, err := netclient.SendWithRetry(ctx, a.httpDoer, endpoint, token, payload, netclient.RetryOptions{
-		MaxRetry:  a.cfg.MaxRetry,
-		BaseDelay: time.Duration(a.cfg.RetryBaseDelay * float64(time.Second)),
-		Debug:     a.cfg.DEBUG,
+	textPath := settings.TEXTPath
+	if request.IsConverse(settings.RequestFormat) && textPath == config.Default().TEXTPath {
+		textPath = request.ConverseTEXTPath
+	}
+	extracted := response.ExtractTextFromResponse(resBody, textPath, a.cfg.TEXTPath)
+	if strings.TrimSpace(extracted) == "" {
+		return "", &ExtractionError{TEXTPath: textPath}
+	}
+	return extracted, nil
+}
+
+// RateLimitStatus returns the client-side rate limiter state of every
+// endpoint/key pair used so far, or nil when rate limiting is off.
+func (a *App) RateLimitStatus() []netclient.RateLimitState {
+	if a.limiter == nil {
+		return nil
+	}
+	return a.limiter.Snapshot()
+}
+
+// probedPool is an endpoint pool with the proxy settings of its requests.
+type probedPool struct {
+	pool  *netclient.EndpointPool
+	proxy netclient.ProxySettings
+}
+
+// LogRateLimitStatus prints the state of every rate limiter group in DEBUG
+// mode.
+func (a *App) LogRateLimitStatus() {
+	if !a.cfg.DEBUG {
+		return
+	}
+	for _, st := range a.RateLimitStatus() {
+		fmt.Printf("[ratelimit] %s\n", st)
+	}
+}
+
+// newPool builds an endpoint pool from eps, or returns nil when eps is empty.
+func newPool(cfg config.Config, eps []config.EndpointConfig, strategy string) (*netclient.EndpointPool, error) {
+	if len(eps) == 0 {
+		return nil, nil
+	}
+	members := make([]netclient.Endpoint, len(eps))
+	for i, e := range eps {
+		members[i] = netclient.Endpoint{URL: e.URL, Token: strings.TrimSpace(e.Token), Priority: e.Priority, HealthCheckURL: e.HealthCheckURL}
+	}
+	return netclient.NewEndpointPool(members, netclient.PoolOptions{
+		Strategy:         strategy,
+		FailureThreshold: cfg.EndpointFailureThreshold,
+		Cooldown:         time.Duration(cfg.EndpointCooldown * float64(time.Second)),
 	})
+}
+
+// newOAuth2 builds the token source of oc, decrypting a ClientSecret that
+// references a secret.
+func newOAuth2(oc config.OAuth2Config, doer netclient.Doer, secrets *secretResolver) (netclient.TokenSource, error) {
+	var err error
+	if oc.ClientSecret, err = secrets.resolve(oc.ClientSecret); err != nil {
+		return nil, err
+	}
+	return netclient.NewOAuth2ClientCredentials(oc, doer)
+}
+
+// newKeyRing builds the key ring for keys, or returns nil when there is at
+// most one key and nothing to rotate.
+func newKeyRing(cfg config.Config, keys []string) (*netclient.KeyRing, error) {
+	if len(keys) < 2 {
+		return nil, nil
+	}
+	ring, err := netclient.NewKeyRing(keys, cfg.TokenRotation, time.Duration(cfg.TokenBenchDuration*float64(time.Second)))
 	if err != nil {
-		if a.cfg.DEBUG {
-			fmt.Printf("[request] failed: %v\n", err)
+		return nil, err
+	}
+	if cfg.DEBUG {
+		ring.Logf = func(format string, args ...interface{}) {
+			fmt.Printf(format+"\n", args...)
 		}
-		a.notifyPlaceholder("[request failed]")
+	}
+	return ring, nil
+}
+
+// escapeModelID escapes a model id for use as one path segment. ':' is
+// escaped as well because Bedrock ids such as "...-v1:0" are expected in
+// their percent-encoded form.
+func escapeModelID(model string) string {
+	return strings.ReplaceAll(url.PathEscape(model), ":", "%3A")
+}
+
+// taskEntry returns the entry of task id if it has a prompt to run.
+func (a *App) taskEntry(id int) (config.HotKeyEntry, bool) {
+	if id < 1 || id > len(a.cfg.HotKeyConfig) {
+		return config.HotKeyEntry{}, false
+	}
+	entry := a.cfg.HotKeyConfig[id-1]
+	if strings.TrimSpace(entry.Prompt) == "" {
+		return config.HotKeyEntry{}, false
+	}
+	return entry, true
+}
+
+// runTask runs task id and emits its lifecycle events.
+func (a *App) runTask(id int) {
+	if _, ok := a.taskEntry(id); !ok {
 		return
 	}
+	entry := a.entryName(id)
+	start := time.Now()
+	a.emit(TaskEvent{Type: EventStart, TaskID: id, Entry: entry})
+	err := a.handleTask(id)
+	a.finishTask(id, time.Since(start), err)
+}
 
-	extracted := response.ExtractTextFromResponse(resBody, runtimeOverrides.TEXTPath, a.cfg.TEXTPath)
-	if strings.TrimSpace(extracted) == "" {
-		a.notifyPlaceholder("[empty result]")
+// finishTask logs the outcome of a task, delivers its failure notification
+// and emits the final lifecycle event.
+func (a *App) finishTask(id int, latency time.Duration, err error) {
+	entry := a.entryName(id)
+	ev := TaskEvent{Type: EventSuccess, TaskID: id, Entry: entry, Latency: latency}
+	if err == nil {
+		a.emit(ev)
 		return
 	}
-	if err := a.textIO.PasteText(extracted); err != nil && a.cfg.DEBUG {
-		fmt.Printf("[paste] failed: %v\n", err)
+	// An empty selection is a no-op rather than a failure: it is worth
+	// neither a placeholder in the target document nor a failure event.
+	if errors.Is(err, errEmptySelection) {
+		ev.Type = EventSkip
+		a.emit(ev)
+		return
+	}
+	f := describeFailure(id, entry, err)
+	ev.Type = EventFailure
+	if f.Kind == FailureCanceled {
+		ev.Type = EventCancel
+	}
+	ev.Failure = &f
+	if a.cfg.DEBUG {
+		fmt.Printf("[task] failed id=%d entry=%s: %v\n", id, entry, err)
 	}
+	text, channel := a.renderFailure(f)
+	ev.Text = text
+	if a.cfg.RequestFailedNotification {
+		a.deliverFailure(f, text, channel)
+	}
+	a.emit(ev)
 }
 
-func (a *App) notifyPlaceholder(text string) {
-	if !a.cfg.RequestFailedNotification {
-		return
+// entryName is the label of a task in logs and notifications.
+func (a *App) entryName(id int) string {
+	if id < 1 || id > len(a.cfg.HotKeyConfig) {
+		return fmt.Sprintf("#%d", id)
+	}
+	e := a.cfg.HotKeyConfig[id-1]
+	if name := strings.TrimSpace(e.Name); name != "" {
+		return name
+	}
+	if hk := strings.TrimSpace(e.HotKey); hk != "" {
+		return hk
 	}
-	_ = a.textIO.PasteText(text)
+	return fmt.Sprintf("#%d", id)
 }
@@ -1,15 +1,23 @@
 package app
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"stp/internal/config"
+	"stp/internal/netclient"
+	"stp/internal/secret"
 )
 
 type fakeTextIO struct {
@@ -103,6 +111,147 @@ func TestRequestFailedNotificationOnEmptyResult(t *testing.T) {
 	waitFor(t, func() bool { return ioMock.pastedContains("[empty result]") })
 }
 
+func TestEntryFieldsOverrideGlobal(t *testing.T) {
+	cfg := baseConfig()
+	cfg.Model = "global-model"
+	cfg.Token = "global-token"
+	cfg.HotKeyConfig[0].Model = "entry-model"
+	cfg.HotKeyConfig[0].APIEndpoint = "https://entry"
+	cfg.HotKeyConfig[0].ExtraConfig = `{"Token":"legacy-token"}`
+	ioMock := &fakeTextIO{copyText: "hello"}
+
+	var gotURL, gotAuth, gotBody string
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		b, _ := io.ReadAll(req.Body)
+		gotURL, gotAuth, gotBody = req.URL.String(), req.Header.Get("Authorization"), string(b)
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"text":"ok"}`))}, nil
+	}}
+	a, err := New(cfg, doer, ioMock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Start()
+	defer a.Close()
+
+	a.EnqueueTask(1)
+	waitFor(t, func() bool { return ioMock.pastedContains("ok") })
+	if gotURL != "https://entry" || gotAuth != "Bearer legacy-token" {
+		t.Fatalf("unexpected url=%s auth=%s", gotURL, gotAuth)
+	}
+	if !strings.Contains(gotBody, `"model":"entry-model"`) {
+		t.Fatalf("entry model not used: %s", gotBody)
+	}
+}
+
+func TestHandleTaskReturnsTypedErrors(t *testing.T) {
+	cfg := baseConfig()
+	ioMock := &fakeTextIO{copyText: "hello"}
+	body := `{"error":{"message":"invalid api key"}}`
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 401, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}}
+	a, err := New(cfg, doer, ioMock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statusErr *netclient.StatusError
+	if err := a.handleTask(1); !errors.As(err, &statusErr) || statusErr.Message != "invalid api key" {
+		t.Fatalf("expected StatusError, got %#v", err)
+	}
+
+	body = `{}`
+	doer.fn = func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}
+	a, _ = New(cfg, doer, ioMock)
+	var extractErr *ExtractionError
+	if err := a.handleTask(1); !errors.As(err, &extractErr) {
+		t.Fatalf("expected ExtractionError, got %#v", err)
+	}
+
+	a, _ = New(cfg, doer, &fakeTextIO{copyText: "  "})
+	var clipErr *ClipboardError
+	if err := a.handleTask(1); !errors.As(err, &clipErr) || clipErr.Op != "copy" {
+		t.Fatalf("expected ClipboardError, got %#v", err)
+	}
+}
+
+type recordingNotifier struct {
+	mu     sync.Mutex
+	events []TaskEvent
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, ev TaskEvent) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, ev)
+	return nil
+}
+
+func (n *recordingNotifier) types() []EventType {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	out := []EventType{}
+	for _, ev := range n.events {
+		out = append(out, ev.Type)
+	}
+	return out
+}
+
+func TestFailureNotificationTemplatesAndChannels(t *testing.T) {
+	cfg := baseConfig()
+	cfg.RequestFailedNotification = true
+	cfg.HotKeyConfig[0].Name = "translate-en"
+	cfg.FailureNotifications = map[string]config.NotificationRule{
+		"status":       {Template: "[{{.Entry}}: HTTP {{.Status}} {{.Message}} after {{.Attempts}}]"},
+		"empty_result": {Channel: "notifier"},
+	}
+	ioMock := &fakeTextIO{copyText: "hello"}
+	status := 401
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		body := `{"error":{"message":"invalid api key"}}`
+		if status == 200 {
+			body = `{}`
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}}
+	a, err := New(cfg, doer, ioMock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &recordingNotifier{}
+	a.AddNotifier(n, EventFailure)
+
+	a.finishTask(1, 0, a.handleTask(1))
+	if !ioMock.pastedContains("[translate-en: HTTP 401 invalid api key after 1]") {
+		t.Fatalf("templated placeholder not pasted: %#v", ioMock.pasted)
+	}
+
+	status = 200
+	a.finishTask(1, 0, a.handleTask(1))
+	a.notifyWG.Wait()
+	if len(n.events) != 2 {
+		t.Fatalf("expected two failure events, got %#v", n.events)
+	}
+	var empty *TaskEvent
+	for i := range n.events {
+		if n.events[i].Failure.Kind == FailureEmptyResult {
+			empty = &n.events[i]
+		}
+	}
+	if empty == nil || empty.Text != "[empty result]" {
+		t.Fatalf("empty result should reach the notifier with default text: %#v", n.events)
+	}
+	if ioMock.pastedContains("[empty result]") {
+		t.Fatalf("notifier channel must not paste")
+	}
+
+	cfg.FailureNotifications = map[string]config.NotificationRule{"status": {Channel: "fax"}}
+	if _, err := New(cfg, doer, ioMock); err == nil {
+		t.Fatalf("expected error for unknown channel")
+	}
+}
+
 func TestStopAllCancelsCurrentAndClearsQueue(t *testing.T) {
 	cfg := baseConfig()
 	cfg.RequestFailedNotification = false
@@ -161,6 +310,48 @@ func TestConcurrentEnqueueProcessesSerially(t *testing.T) {
 	waitFor(t, func() bool { return ioMock.getCopyCalls() == 20 })
 }
 
+func TestDrainFinishesRunningAndQueuedTasks(t *testing.T) {
+	cfg := baseConfig()
+	cfg.RequestFailedNotification = true
+	ioMock := &fakeTextIO{copyText: "hello"}
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		select {
+		case <-release:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"text":"ok"}`))}, nil
+	}}
+	a, err := New(cfg, doer, ioMock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Start()
+	defer a.Close()
+
+	a.EnqueueTask(1)
+	<-started
+	a.EnqueueTask(1)
+	a.Drain()
+	a.EnqueueTask(1) // rejected while draining
+	close(release)
+
+	waitFor(t, a.Closed)
+	ioMock.mu.Lock()
+	pasted := append([]string(nil), ioMock.pasted...)
+	ioMock.mu.Unlock()
+	if len(pasted) != 2 || pasted[0] != "ok" || pasted[1] != "ok" {
+		t.Fatalf("expected the running and the queued task to finish, pasted %q", pasted)
+	}
+}
+
 func waitFor(t *testing.T, check func() bool) {
 	t.Helper()
 	deadline := time.Now().Add(2 * time.Second)
@@ -172,3 +363,574 @@ func waitFor(t *testing.T, check func() bool) {
 	}
 	t.Fatalf("timeout waiting for condition")
 }
+
+func TestTaskTimeoutCoversRetries(t *testing.T) {
+	cfg := baseConfig()
+	cfg.MaxRetry = 5
+	cfg.RetryBaseDelay = 0.01
+	tt := 0.1
+	cfg.HotKeyConfig[0].TaskTimeout = &tt
+	ioMock := &fakeTextIO{copyText: "hello"}
+	calls := 0
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		calls++
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}}
+	a, err := New(cfg, doer, ioMock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	err = a.handleTask(1)
+	var timeoutErr *netclient.TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected TimeoutError, got %#v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second || calls != 1 {
+		t.Fatalf("task deadline not enforced: elapsed=%v calls=%d", elapsed, calls)
+	}
+	if len(ioMock.pasted) != 0 {
+		t.Fatalf("nothing should be pasted after the deadline")
+	}
+}
+
+func TestHeadersTemplatesAndAuthScheme(t *testing.T) {
+	t.Setenv("STP_TEST_TENANT", "acme")
+	cfg := baseConfig()
+	cfg.Token = "secret"
+	cfg.Model = "gpt-test"
+	cfg.UserAgent = "stp-test/1"
+	cfg.Headers = map[string]string{
+		"X-Tenant": `{{env "STP_TEST_TENANT"}}`,
+		"X-Trace":  "on",
+	}
+	cfg.HotKeyConfig[0].Name = "translate-en"
+	cfg.HotKeyConfig[0].AuthScheme = "header"
+	cfg.HotKeyConfig[0].Headers = map[string]string{
+		"x-trace":  "",
+		"X-Source": "stp/{{.Entry}}/{{.Model}}",
+	}
+	var got http.Header
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		got = req.Header.Clone()
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"choices":[{"message":{"content":"ok"}}]}`))}, nil
+	}}
+	a, err := New(cfg, doer, &fakeTextIO{copyText: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.handleTask(1); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("X-Tenant") != "acme" || got.Get("X-Source") != "stp/translate-en/gpt-test" || got.Get("X-Trace") != "" {
+		t.Fatalf("unexpected headers: %v", got)
+	}
+	if got.Get("api-key") != "secret" || got.Get("Authorization") != "" || got.Get("User-Agent") != "stp-test/1" {
+		t.Fatalf("unexpected auth headers: %v", got)
+	}
+
+	cfg.Headers = map[string]string{"X-Bad": "{{.Unknown}}"}
+	if _, err := New(cfg, doer, &fakeTextIO{}); err == nil {
+		t.Fatal("expected unknown template field to be rejected")
+	}
+	cfg.Headers = nil
+	cfg.HotKeyConfig[0].AuthScheme = "digest"
+	if _, err := New(cfg, doer, &fakeTextIO{}); err == nil {
+		t.Fatal("expected unknown auth scheme to be rejected")
+	}
+}
+
+func TestOAuth2TokenSourcePerEntry(t *testing.T) {
+	cfg := baseConfig()
+	cfg.OAuth2 = &config.OAuth2Config{TokenURL: "https://sso.example/token", ClientID: "stp"}
+	cfg.HotKeyConfig = append(cfg.HotKeyConfig,
+		config.HotKeyEntry{Prompt: "summarize", HotKey: "ctrl+f2"},
+		config.HotKeyEntry{Prompt: "other", HotKey: "ctrl+f3", OAuth2: &config.OAuth2Config{TokenURL: "https://other.example/token", ClientID: "x"}},
+	)
+	var mu sync.Mutex
+	fetches := map[string]int{}
+	var auth []string
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasSuffix(req.URL.Path, "/token") {
+			fetches[req.URL.Host]++
+			body := fmt.Sprintf(`{"access_token":"%s-%d","expires_in":3600}`, req.URL.Host, fetches[req.URL.Host])
+			return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+		}
+		auth = append(auth, req.Header.Get("Authorization"))
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"choices":[{"message":{"content":"ok"}}]}`))}, nil
+	}}
+	a, err := New(cfg, doer, &fakeTextIO{copyText: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{1, 2, 3} {
+		if err := a.handleTask(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"Bearer sso.example-1", "Bearer sso.example-1", "Bearer other.example-1"}
+	if fmt.Sprint(auth) != fmt.Sprint(want) || fetches["sso.example"] != 1 {
+		t.Fatalf("unexpected tokens %v (fetches %v)", auth, fetches)
+	}
+
+	cfg.HotKeyConfig[1].OAuth2 = &config.OAuth2Config{TokenURL: "sso"}
+	if _, err := New(cfg, doer, &fakeTextIO{}); err == nil {
+		t.Fatal("expected invalid entry OAuth2 to be rejected")
+	}
+}
+
+func TestBedrockConverseWithSigV4(t *testing.T) {
+	cfg := baseConfig()
+	cfg.APIEndpoint = "https://bedrock-runtime.us-east-1.amazonaws.com/model/{model}/converse"
+	cfg.Model = "anthropic.claude-3-haiku-20240307-v1:0"
+	cfg.AuthScheme = "sigv4"
+	cfg.RequestFormat = "bedrock-converse"
+	cfg.SigV4 = &config.SigV4Config{Region: "us-east-1", AccessKeyID: "AKID", SecretAccessKey: "secret"}
+	var gotPath, gotAuth string
+	var gotBody map[string]interface{}
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		gotPath, gotAuth = req.URL.EscapedPath(), req.Header.Get("Authorization")
+		b, _ := io.ReadAll(req.Body)
+		_ = json.Unmarshal(b, &gotBody)
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(
+			`{"output":{"message":{"role":"assistant","content":[{"text":"bonjour"}]}},"stopReason":"end_turn"}`))}, nil
+	}}
+	ioMock := &fakeTextIO{copyText: "hello"}
+	a, err := New(cfg, doer, ioMock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.handleTask(1); err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/model/anthropic.claude-3-haiku-20240307-v1%3A0/converse" {
+		t.Fatalf("unexpected path %s", gotPath)
+	}
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AKID/") {
+		t.Fatalf("request not signed: %q", gotAuth)
+	}
+	if _, ok := gotBody["system"]; !ok || gotBody["model"] != nil {
+		t.Fatalf("unexpected converse body: %v", gotBody)
+	}
+	if !ioMock.pastedContains("bonjour") {
+		t.Fatalf("converse reply not extracted: %v", ioMock.pasted)
+	}
+
+	cfg.SigV4 = &config.SigV4Config{}
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	if _, err := New(cfg, doer, &fakeTextIO{}); err == nil {
+		t.Fatal("expected missing SigV4 credentials to be rejected")
+	}
+}
+
+func TestSigV4FallbackToOtherProvider(t *testing.T) {
+	cfg := baseConfig()
+	cfg.APIEndpoint = "https://bedrock-runtime.us-east-1.amazonaws.com/model/{model}/converse"
+	cfg.Model = "anthropic.claude-3-haiku-20240307-v1:0"
+	cfg.AuthScheme = "sigv4"
+	cfg.RequestFormat = "bedrock-converse"
+	cfg.SigV4 = &config.SigV4Config{Region: "us-east-1", AccessKeyID: "AKID", SecretAccessKey: "secret"}
+	cfg.HotKeyConfig[0].Fallbacks = []config.FallbackConfig{{ModelOverride: config.ModelOverride{
+		Model: "gpt-4o-mini", APIEndpoint: "https://backup/v1/chat/completions", Token: "sk-backup",
+		AuthScheme: "bearer", RequestFormat: "openai",
+	}}}
+	var backupAuth string
+	var backupBody map[string]interface{}
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host != "backup" {
+			return &http.Response{StatusCode: 503, Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+		}
+		backupAuth = req.Header.Get("Authorization")
+		b, _ := io.ReadAll(req.Body)
+		_ = json.Unmarshal(b, &backupBody)
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"choices":[{"message":{"content":"ok"}}]}`))}, nil
+	}}
+	ioMock := &fakeTextIO{copyText: "hello"}
+	a, err := New(cfg, doer, ioMock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.handleTask(1); err != nil {
+		t.Fatal(err)
+	}
+	if backupAuth != "Bearer sk-backup" {
+		t.Fatalf("fallback must not be signed for the entry's endpoint: %q", backupAuth)
+	}
+	if backupBody["model"] != "gpt-4o-mini" || backupBody["messages"] == nil {
+		t.Fatalf("fallback must use its own RequestFormat: %v", backupBody)
+	}
+	if !ioMock.pastedContains("ok") {
+		t.Fatalf("fallback reply not pasted: %v", ioMock.pasted)
+	}
+
+	cfg.HotKeyConfig[0].Fallbacks[0].AuthScheme = ""
+	if _, err := New(cfg, doer, &fakeTextIO{}); err == nil {
+		t.Fatal("expected a sigv4 fallback to another endpoint without AuthScheme to be rejected")
+	}
+	cfg.HotKeyConfig[0].Fallbacks[0].AuthScheme = "sigv4"
+	if _, err := New(cfg, doer, &fakeTextIO{}); err == nil {
+		t.Fatal("expected AuthScheme sigv4 in a fallback to be rejected")
+	}
+}
+
+func TestEndpointPoolFailoverAndEntryOverride(t *testing.T) {
+	cfg := baseConfig()
+	cfg.MaxRetry = 2
+	cfg.RetryBaseDelay = 0
+	cfg.Token = "test-token"
+	cfg.Endpoints = []config.EndpointConfig{
+		{URL: "https://gw-a/v1", Token: "tok-a"},
+		{URL: "https://gw-b/v1", Token: "tok-b", Priority: 1},
+	}
+	cfg.HotKeyConfig = append(cfg.HotKeyConfig,
+		config.HotKeyEntry{Prompt: "direct", HotKey: "ctrl+f2", APIEndpoint: "https://direct/v1"},
+		config.HotKeyEntry{Prompt: "own", HotKey: "ctrl+f3", Endpoints: []config.EndpointConfig{{URL: "https://own/v1"}}},
+	)
+	var mu sync.Mutex
+	var hits []string
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		hits = append(hits, req.URL.Host+" "+req.Header.Get("Authorization"))
+		if req.URL.Host == "gw-a" {
+			return &http.Response{StatusCode: 503, Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"choices":[{"message":{"content":"ok"}}]}`))}, nil
+	}}
+	a, err := New(cfg, doer, &fakeTextIO{copyText: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{1, 1, 2, 3} {
+		if err := a.handleTask(id); err != nil {
+			t.Fatalf("task %d: %v", id, err)
+		}
+	}
+	want := []string{"gw-a Bearer tok-a", "gw-b Bearer tok-b", "gw-b Bearer tok-b", "direct Bearer test-token", "own Bearer test-token"}
+	if fmt.Sprint(hits) != fmt.Sprint(want) {
+		t.Fatalf("unexpected requests %q", hits)
+	}
+
+	cfg.EndpointStrategy = "fastest"
+	if _, err := New(cfg, doer, &fakeTextIO{}); err == nil {
+		t.Fatal("expected unknown strategy to be rejected")
+	}
+}
+
+func TestHealthProbesUseEntryProxy(t *testing.T) {
+	var probes atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Store(r.Method + " " + r.URL.String())
+	}))
+	defer proxy.Close()
+
+	cfg := baseConfig()
+	cfg.HealthCheckInterval = 0.02
+	cfg.HotKeyConfig[0].Proxy = proxy.URL
+	cfg.HotKeyConfig[0].Endpoints = []config.EndpointConfig{{URL: "http://own.invalid/v1", HealthCheckURL: "http://own.invalid/healthz"}}
+	client, _, err := netclient.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(cfg, client, &fakeTextIO{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Start()
+	defer a.Close()
+	waitFor(t, func() bool { return probes.Load() != nil })
+	if got := probes.Load(); got != "GET http://own.invalid/healthz" {
+		t.Fatalf("unexpected probe through the proxy: %v", got)
+	}
+}
+
+func TestFallbackChainConditions(t *testing.T) {
+	cfg := baseConfig()
+	cfg.Model = "primary"
+	cfg.HotKeyConfig[0].Fallbacks = []config.FallbackConfig{
+		{ModelOverride: config.ModelOverride{Model: "never"}, OnStatus: []int{503}},
+		{ModelOverride: config.ModelOverride{Model: "long-context", ExtraConfig: `{"max_completion_tokens":64}`}, OnError: []string{"context_length_exceeded"}},
+		{ModelOverride: config.ModelOverride{Model: "filtered", APIEndpoint: "https://backup"}, OnEmptyResult: true},
+	}
+	var mu sync.Mutex
+	var models []string
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		var body map[string]interface{}
+		b, _ := io.ReadAll(req.Body)
+		_ = json.Unmarshal(b, &body)
+		model, _ := body["model"].(string)
+		models = append(models, model+"@"+req.URL.Host)
+		switch model {
+		case "primary":
+			return &http.Response{StatusCode: 400, Body: io.NopCloser(strings.NewReader(
+				`{"error":{"message":"too long","code":"context_length_exceeded"}}`))}, nil
+		case "long-context":
+			if body["max_completion_tokens"] != float64(64) {
+				t.Errorf("fallback ExtraConfig not merged: %v", body)
+			}
+			return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(
+				`{"choices":[{"message":{"content":""},"finish_reason":"content_filter"}]}`))}, nil
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"choices":[{"message":{"content":"ok"}}]}`))}, nil
+	}}
+	ioMock := &fakeTextIO{copyText: "hello"}
+	a, err := New(cfg, doer, ioMock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.handleTask(1); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"primary@example", "long-context@example", "filtered@backup"}
+	if fmt.Sprint(models) != fmt.Sprint(want) || !ioMock.pastedContains("ok") {
+		t.Fatalf("unexpected chain %v (pasted %v)", models, ioMock.pasted)
+	}
+
+	// A failure no fallback matches is returned as is.
+	cfg.HotKeyConfig[0].Fallbacks = cfg.HotKeyConfig[0].Fallbacks[:1]
+	models = nil
+	a, _ = New(cfg, doer, &fakeTextIO{copyText: "hello"})
+	var se *netclient.StatusError
+	if err := a.handleTask(1); !errors.As(err, &se) || se.StatusCode != 400 || len(models) != 1 {
+		t.Fatalf("expected unmatched 400, got %v after %v", err, models)
+	}
+
+	cfg.HotKeyConfig[0].Fallbacks = []config.FallbackConfig{{OnError: []string{"("}}}
+	if _, err := New(cfg, doer, &fakeTextIO{}); err == nil {
+		t.Fatal("expected invalid OnError pattern to be rejected")
+	}
+}
+
+func TestFanOutFirstWinsAndCombined(t *testing.T) {
+	cfg := baseConfig()
+	cfg.Model = "slow"
+	cfg.HotKeyConfig[0].FanOut = &config.FanOutConfig{Targets: []config.FanOutTarget{
+		{Label: "Fast", ModelOverride: config.ModelOverride{Model: "fast"}},
+	}}
+	var slowCanceled int32
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		var body map[string]interface{}
+		b, _ := io.ReadAll(req.Body)
+		_ = json.Unmarshal(b, &body)
+		if body["model"] == "slow" {
+			select {
+			case <-req.Context().Done():
+				atomic.AddInt32(&slowCanceled, 1)
+				return nil, req.Context().Err()
+			case <-time.After(2 * time.Second):
+			}
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(
+			fmt.Sprintf(`{"choices":[{"message":{"content":"from %s"}}]}`, body["model"])))}, nil
+	}}
+	ioMock := &fakeTextIO{copyText: "hello"}
+	a, err := New(cfg, doer, ioMock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.handleTask(1); err != nil {
+		t.Fatal(err)
+	}
+	if !ioMock.pastedContains("from fast") || atomic.LoadInt32(&slowCanceled) != 1 {
+		t.Fatalf("first-wins: pasted %v, slow canceled %d", ioMock.pasted, slowCanceled)
+	}
+
+	cfg.Model = "a"
+	cfg.HotKeyConfig[0].FanOut = &config.FanOutConfig{Mode: "all", Targets: []config.FanOutTarget{
+		{ModelOverride: config.ModelOverride{Model: "b"}},
+		{Label: "C", ModelOverride: config.ModelOverride{Model: "c", APIEndpoint: "https://down"}},
+	}}
+	doer = fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host == "down" {
+			return &http.Response{StatusCode: 500, Body: io.NopCloser(strings.NewReader(`{"error":"boom"}`))}, nil
+		}
+		var body map[string]interface{}
+		b, _ := io.ReadAll(req.Body)
+		_ = json.Unmarshal(b, &body)
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(
+			fmt.Sprintf(`{"choices":[{"message":{"content":"from %s"}}]}`, body["model"])))}, nil
+	}}
+	ioMock = &fakeTextIO{copyText: "hello"}
+	a, _ = New(cfg, doer, ioMock)
+	if err := a.handleTask(1); err != nil {
+		t.Fatal(err)
+	}
+	want := "[a]\nfrom a\n\n[b]\nfrom b\n\n[C]\n[request failed] status 500: boom"
+	if len(ioMock.pasted) != 1 || ioMock.pasted[0] != want {
+		t.Fatalf("unexpected combined output %q", ioMock.pasted)
+	}
+
+	cfg.HotKeyConfig[0].FanOut.Mode = "vote"
+	if _, err := New(cfg, doer, &fakeTextIO{}); err == nil {
+		t.Fatal("expected unknown fan-out mode to be rejected")
+	}
+}
+
+func TestFanOutStopCancelsAllRequests(t *testing.T) {
+	cfg := baseConfig()
+	cfg.HotKeyConfig[0].FanOut = &config.FanOutConfig{Mode: "all", Targets: []config.FanOutTarget{
+		{ModelOverride: config.ModelOverride{Model: "other"}},
+	}}
+	var started, canceled int32
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&started, 1)
+		<-req.Context().Done()
+		atomic.AddInt32(&canceled, 1)
+		return nil, req.Context().Err()
+	}}
+	a, _ := New(cfg, doer, &fakeTextIO{copyText: "hello"})
+	done := make(chan error, 1)
+	go func() { done <- a.handleTask(1) }()
+	waitFor(t, func() bool { return atomic.LoadInt32(&started) == 2 })
+	a.StopAll()
+	var ce *netclient.CanceledError
+	if err := <-done; !errors.As(err, &ce) || atomic.LoadInt32(&canceled) != 2 {
+		t.Fatalf("expected both requests canceled, got %v (%d)", err, canceled)
+	}
+}
+
+func TestRateLimitStatusPerKey(t *testing.T) {
+	cfg := baseConfig()
+	cfg.Token = "sk-secret"
+	cfg.RateLimitRPM = 30
+	cfg.HotKeyConfig = append(cfg.HotKeyConfig, config.HotKeyEntry{Prompt: "other", HotKey: "ctrl+f2", Token: "sk-other"})
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"choices":[{"message":{"content":"ok"}}]}`))}, nil
+	}}
+	a, err := New(cfg, doer, &fakeTextIO{copyText: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{1, 1, 2} {
+		if err := a.handleTask(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st := a.RateLimitStatus()
+	if len(st) != 2 {
+		t.Fatalf("expected one state per key, got %v", st)
+	}
+	for _, s := range st {
+		if strings.Contains(s.String(), "sk-") {
+			t.Fatalf("status leaks the key: %s", s)
+		}
+	}
+
+	// The limiter is opt-in: the defaults leave it off.
+	cfg.RateLimitRPM = config.Default().RateLimitRPM
+	if a, _ = New(cfg, doer, &fakeTextIO{}); a.RateLimitStatus() != nil {
+		t.Fatal("expected no limiter by default")
+	}
+}
+
+func TestCircuitOpenReportedAsUnavailable(t *testing.T) {
+	cfg := baseConfig()
+	cfg.CircuitBreakerThreshold = 2
+	cfg.RequestFailedNotification = true
+	var hits int32
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&hits, 1)
+		return nil, errors.New("connection refused")
+	}}
+	ioMock := &fakeTextIO{copyText: "hello"}
+	a, err := New(cfg, doer, ioMock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		a.runTask(1)
+	}
+	if hits != 2 {
+		t.Fatalf("expected the circuit to stop requests after 2 failures, got %d", hits)
+	}
+	want := []string{"[request failed]", "[request failed]", "[endpoint unavailable]"}
+	if fmt.Sprint(ioMock.pasted) != fmt.Sprint(want) {
+		t.Fatalf("unexpected notifications %q", ioMock.pasted)
+	}
+	if f := describeFailure(1, "x", a.handleTask(1)); f.Kind != FailureUnavailable || f.Message == "" {
+		t.Fatalf("unexpected failure %+v", f)
+	}
+}
+
+func TestTokenRotationPerEntryAndGlobal(t *testing.T) {
+	cfg := baseConfig()
+	cfg.Token = config.NewTokenList("g1", "g2")
+	cfg.HotKeyConfig = append(cfg.HotKeyConfig,
+		config.HotKeyEntry{Prompt: "own", HotKey: "ctrl+f2", Token: config.NewTokenList("e1", "e2")},
+		config.HotKeyEntry{Prompt: "single", HotKey: "ctrl+f3", Token: "solo"},
+		config.HotKeyEntry{Prompt: "legacy", HotKey: "ctrl+f4", ExtraConfig: `{"Token":"old"}`},
+	)
+	var mu sync.Mutex
+	var used []string
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		used = append(used, strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+		mu.Unlock()
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"choices":[{"message":{"content":"ok"}}]}`))}, nil
+	}}
+	a, err := New(cfg, doer, &fakeTextIO{copyText: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{1, 2, 1, 2, 3, 4, 1} {
+		if err := a.handleTask(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := strings.Join(used, ","); got != "g1,e1,g2,e2,solo,old,g1" {
+		t.Fatalf("unexpected keys %s", got)
+	}
+
+	cfg.TokenRotation = "random"
+	if _, err := New(cfg, doer, &fakeTextIO{}); err == nil {
+		t.Fatal("expected invalid TokenRotation to be rejected")
+	}
+}
+
+func TestSecretReferencesResolvedPerRequest(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(secret.PassphraseEnv, "pw")
+	store, err := secret.Open(filepath.Join(dir, "secrets.json"), secret.Options{Passphrase: "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("openai", "sk-live"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("org", "acme"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := baseConfig()
+	cfg.SecretsFile = filepath.Join(dir, "secrets.json")
+	cfg.Token = "secret:openai"
+	cfg.Headers = map[string]string{"X-Org": "secret:org"}
+	cfg.HotKeyConfig = append(cfg.HotKeyConfig, config.HotKeyEntry{Prompt: "b", HotKey: "ctrl+f2", Token: "secret:missing"})
+	var auth, org string
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		auth, org = req.Header.Get("Authorization"), req.Header.Get("X-Org")
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"choices":[{"message":{"content":"ok"}}]}`))}, nil
+	}}
+	a, err := New(cfg, doer, &fakeTextIO{copyText: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.handleTask(1); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer sk-live" || org != "acme" {
+		t.Fatalf("secrets not resolved: auth=%q org=%q", auth, org)
+	}
+	if a.cfg.Token != "secret:openai" {
+		t.Fatalf("config must keep the reference, got %q", a.cfg.Token)
+	}
+	if err := a.handleTask(2); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("expected unknown secret to fail the task, got %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ExtractionError reports that the response did not yield any text at TEXTPath.
+type ExtractionError struct {
+	TEXTPath string
+}
+
+func (e *ExtractionError) Error() string {
+	return fmt.Sprintf("no text extracted (TEXTPath=%q)", e.TEXTPath)
+}
+
+// ClipboardError reports a failure while copying the selection or pasting
+// the result.
+type ClipboardError struct {
+	Op  string
+	Err error
+}
+
+func (e *ClipboardError) Error() string {
+	return fmt.Sprintf("clipboard %s failed: %v", e.Op, e.Err)
+}
+
+func (e *ClipboardError) Unwrap() error { return e.Err }
+
+// errEmptySelection is wrapped in a ClipboardError when nothing was selected.
+var errEmptySelection = errors.New("empty selection")
@@ -0,0 +1,151 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"stp/internal/config"
+	"stp/internal/netclient"
+	"stp/internal/request"
+)
+
+// override is a compiled ModelOverride.
+type override struct {
+	config.ModelOverride
+	extra map[string]interface{}
+}
+
+func compileOverride(mo config.ModelOverride) (override, error) {
+	if err := request.ValidateFormat(mo.RequestFormat); err != nil {
+		return override{}, fmt.Errorf("invalid RequestFormat: %w", err)
+	}
+	if err := netclient.ValidateAuth(mo.AuthScheme, mo.AuthParam); err != nil {
+		return override{}, fmt.Errorf("invalid AuthScheme: %w", err)
+	}
+	// The SigV4 signer belongs to the entry and its endpoint.
+	if strings.EqualFold(strings.TrimSpace(mo.AuthScheme), netclient.AuthSigV4) {
+		return override{}, fmt.Errorf("AuthScheme sigv4 is only supported on entries")
+	}
+	extra, err := request.ParseExtraConfig(string(mo.ExtraConfig))
+	if err != nil {
+		return override{}, fmt.Errorf("invalid ExtraConfig: %w", err)
+	}
+	return override{ModelOverride: mo, extra: extra}, nil
+}
+
+// apply returns entry with the override's fields.
+func (o override) apply(entry config.HotKeyEntry) config.HotKeyEntry {
+	if v := strings.TrimSpace(o.Model); v != "" {
+		entry.Model = v
+	}
+	if v := strings.TrimSpace(o.APIEndpoint); v != "" {
+		entry.APIEndpoint = v
+	}
+	if v := strings.TrimSpace(o.Token); v != "" {
+		entry.Token = config.TokenList(v)
+	}
+	if v := strings.TrimSpace(o.TEXTPath); v != "" {
+		entry.TEXTPath = v
+	}
+	if v := strings.TrimSpace(o.RequestFormat); v != "" {
+		entry.RequestFormat = v
+	}
+	if v := strings.TrimSpace(o.AuthScheme); v != "" {
+		entry.AuthScheme, entry.AuthParam = v, strings.TrimSpace(o.AuthParam)
+	} else if v := strings.TrimSpace(o.AuthParam); v != "" {
+		entry.AuthParam = v
+	}
+	return entry
+}
+
+// keepsSigner reports whether requests of the override may still be signed
+// with the entry's SigV4 signer: only when they go to the entry's endpoint
+// with the entry's auth scheme.
+func (o override) keepsSigner() bool {
+	return strings.TrimSpace(o.APIEndpoint) == "" && strings.TrimSpace(o.AuthScheme) == ""
+}
+
+// checkSignedOverrides rejects the overrides of a SigV4 entry that move to
+// another endpoint without an auth scheme of their own, since their
+// requests would go out unsigned.
+func checkSignedOverrides(fbs []fallback, fo *fanOut) error {
+	for i, fb := range fbs {
+		if strings.TrimSpace(fb.APIEndpoint) != "" && strings.TrimSpace(fb.AuthScheme) == "" {
+			return fmt.Errorf("fallback %d: an APIEndpoint of a sigv4 entry needs its own AuthScheme", i)
+		}
+	}
+	if fo != nil {
+		for i, t := range fo.targets[1:] {
+			if strings.TrimSpace(t.ov.APIEndpoint) != "" && strings.TrimSpace(t.ov.AuthScheme) == "" {
+				return fmt.Errorf("FanOut target %d: an APIEndpoint of a sigv4 entry needs its own AuthScheme", i)
+			}
+		}
+	}
+	return nil
+}
+
+// fallback is a compiled FallbackConfig.
+type fallback struct {
+	override
+	onStatus      []int
+	onEmptyResult bool
+	patterns      []*regexp.Regexp
+}
+
+func compileFallbacks(fcs []config.FallbackConfig) ([]fallback, error) {
+	out := make([]fallback, 0, len(fcs))
+	for i, fc := range fcs {
+		ov, err := compileOverride(fc.ModelOverride)
+		if err != nil {
+			return nil, fmt.Errorf("fallback %d: %w", i, err)
+		}
+		fb := fallback{override: ov, onStatus: fc.OnStatus, onEmptyResult: fc.OnEmptyResult}
+		for _, p := range fc.OnError {
+			re, err := regexp.Compile("(?i)" + p)
+			if err != nil {
+				return nil, fmt.Errorf("fallback %d: invalid OnError pattern %q: %w", i, p, err)
+			}
+			fb.patterns = append(fb.patterns, re)
+		}
+		out = append(out, fb)
+	}
+	return out, nil
+}
+
+// matches reports whether err, the failure of the previous step, triggers
+// this fallback. Cancellation and clipboard failures never do.
+func (f fallback) matches(err error) bool {
+	var canceled *netclient.CanceledError
+	var clip *ClipboardError
+	if err == nil || errors.As(err, &canceled) || errors.As(err, &clip) {
+		return false
+	}
+	var extraction *ExtractionError
+	isEmpty := errors.As(err, &extraction)
+	if len(f.onStatus) == 0 && len(f.patterns) == 0 && !f.onEmptyResult {
+		return true
+	}
+	if isEmpty && f.onEmptyResult {
+		return true
+	}
+	msg := err.Error()
+	var se *netclient.StatusError
+	if errors.As(err, &se) {
+		for _, code := range f.onStatus {
+			if code == se.StatusCode {
+				return true
+			}
+		}
+		// Providers put codes such as "context_length_exceeded" in the
+		// body next to the message.
+		msg += "\n" + se.Body
+	}
+	for _, re := range f.patterns {
+		if re.MatchString(msg) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"stp/internal/config"
+)
+
+// Fan-out modes.
+const (
+	fanOutFirst = "first"
+	fanOutAll   = "all"
+)
+
+// defaultFanOutTemplate labels every answer of an "all" fan-out.
+const defaultFanOutTemplate = "{{range $i, $r := .Results}}{{if $i}}\n\n{{end}}[{{$r.Label}}]\n" +
+	"{{if $r.Error}}[request failed] {{$r.Error}}{{else}}{{$r.Text}}{{end}}{{end}}"
+
+// fanOut is a compiled FanOutConfig. The first target is the entry itself.
+type fanOut struct {
+	all     bool
+	targets []fanOutTarget
+	tmpl    *template.Template
+}
+
+type fanOutTarget struct {
+	label string
+	ov    *override // nil for the entry itself
+}
+
+// fanOutResult is one answer as seen by the FanOut template.
+type fanOutResult struct {
+	Label string
+	Model string
+	Text  string
+	Error string
+}
+
+func compileFanOut(fc *config.FanOutConfig) (*fanOut, error) {
+	if fc == nil || len(fc.Targets) == 0 {
+		return nil, nil
+	}
+	fo := &fanOut{targets: []fanOutTarget{{}}}
+	switch strings.ToLower(strings.TrimSpace(fc.Mode)) {
+	case "", fanOutFirst:
+	case fanOutAll:
+		fo.all = true
+	default:
+		return nil, fmt.Errorf("unknown FanOut mode %q (want first or all)", fc.Mode)
+	}
+	for i, t := range fc.Targets {
+		ov, err := compileOverride(t.ModelOverride)
+		if err != nil {
+			return nil, fmt.Errorf("target %d: %w", i, err)
+		}
+		fo.targets = append(fo.targets, fanOutTarget{label: strings.TrimSpace(t.Label), ov: &ov})
+	}
+	src := fc.Template
+	if strings.TrimSpace(src) == "" {
+		src = defaultFanOutTemplate
+	}
+	tmpl, err := template.New("fanout").Option("missingkey=zero").Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FanOut template: %w", err)
+	}
+	fo.tmpl = tmpl
+	return fo, nil
+}
+
+// runFanOut sends the request of entry to every target at once. In "first"
+// mode the first successful answer wins and the other requests are canceled;
+// in "all" mode every answer is combined through the template. When every
+// target fails, the error of the first one is returned.
+func (a *App) runFanOut(ctx context.Context, id int, entry config.HotKeyEntry, fo *fanOut, selectedText string) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type reply struct {
+		i    int
+		text string
+		err  error
+	}
+	results := make([]fanOutResult, len(fo.targets))
+	ch := make(chan reply, len(fo.targets))
+	for i, t := range fo.targets {
+		e := entry
+		if t.ov != nil {
+			e = t.ov.apply(entry)
+		}
+		model := a.cfg.EntrySettings(e).Model
+		results[i] = fanOutResult{Label: t.label, Model: model}
+		if results[i].Label == "" {
+			results[i].Label = model
+		}
+		go func(i int, e config.HotKeyEntry, ov *override) {
+			text, err := a.complete(ctx, id, e, ov, selectedText)
+			ch <- reply{i: i, text: text, err: err}
+		}(i, e, t.ov)
+	}
+
+	errs := make([]error, len(fo.targets))
+	winner := -1
+	for range fo.targets {
+		r := <-ch
+		if r.err != nil {
+			errs[r.i] = r.err
+			results[r.i].Error = r.err.Error()
+			if a.cfg.DEBUG && winner < 0 {
+				fmt.Printf("[fanout] task id=%d target %s failed: %v\n", id, results[r.i].Label, r.err)
+			}
+			continue
+		}
+		results[r.i].Text = r.text
+		if !fo.all && winner < 0 {
+			// Cancel the losers but still wait for them to return.
+			winner = r.i
+			cancel()
+		}
+	}
+	if winner >= 0 {
+		if a.cfg.DEBUG {
+			fmt.Printf("[fanout] task id=%d entry=%s first answer from %s\n", id, a.entryName(id), results[winner].Label)
+		}
+		return results[winner].Text, nil
+	}
+
+	ok := 0
+	for _, err := range errs {
+		if err == nil {
+			ok++
+		}
+	}
+	if ok == 0 {
+		return "", errs[0]
+	}
+	if a.cfg.DEBUG {
+		fmt.Printf("[fanout] task id=%d entry=%s combined %d/%d answers\n", id, a.entryName(id), ok, len(results))
+	}
+	var buf bytes.Buffer
+	data := struct {
+		TaskID  int
+		Entry   string
+		Results []fanOutResult
+	}{id, a.entryName(id), results}
+	if err := fo.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("render FanOut template: %w", err)
+	}
+	return buf.String(), nil
+}
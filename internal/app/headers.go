@@ -0,0 +1,76 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// headerData is the template data of a header value.
+type headerData struct {
+	TaskID   int
+	Entry    string
+	Model    string
+	Endpoint string
+}
+
+var headerFuncs = template.FuncMap{"env": os.Getenv}
+
+// headerSet maps canonical header names to value templates. A nil template
+// removes a header inherited from the global set.
+type headerSet map[string]*template.Template
+
+func compileHeaders(h map[string]string) (headerSet, error) {
+	out := make(headerSet, len(h))
+	for name, value := range h {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		key := http.CanonicalHeaderKey(name)
+		if value == "" {
+			out[key] = nil
+			continue
+		}
+		t, err := template.New(key).Funcs(headerFuncs).Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", key, err)
+		}
+		// Catch references to unknown fields before the first request.
+		if err := t.Execute(io.Discard, headerData{}); err != nil {
+			return nil, fmt.Errorf("header %s: %w", key, err)
+		}
+		out[key] = t
+	}
+	return out, nil
+}
+
+// renderHeaders renders the global headers overridden by the entry headers.
+func renderHeaders(global, entry headerSet, data headerData) (map[string]string, error) {
+	merged := make(headerSet, len(global)+len(entry))
+	for k, t := range global {
+		merged[k] = t
+	}
+	for k, t := range entry {
+		merged[k] = t
+	}
+	out := make(map[string]string, len(merged))
+	for k, t := range merged {
+		if t == nil {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("header %s: %w", k, err)
+		}
+		if strings.ContainsAny(buf.String(), "\r\n") {
+			return nil, fmt.Errorf("header %s: value contains a line break", k)
+		}
+		out[k] = buf.String()
+	}
+	return out, nil
+}
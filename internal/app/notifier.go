@@ -0,0 +1,311 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"stp/internal/config"
+	"stp/internal/netclient"
+)
+
+// EventType is the lifecycle stage of a task.
+type EventType string
+
+const (
+	EventStart   EventType = "start"
+	EventSuccess EventType = "success"
+	EventFailure EventType = "failure"
+	EventCancel  EventType = "cancel"
+	EventSkip    EventType = "skip" // nothing was selected
+)
+
+// TaskEvent is delivered to notifiers. Failure and Text are set for failure
+// and cancel events; Text is the rendered FailureNotifications template.
+type TaskEvent struct {
+	Type    EventType
+	TaskID  int
+	Entry   string
+	Latency time.Duration
+	Failure *Failure
+	Text    string
+}
+
+// Notifier delivers task events outside the target document.
+type Notifier interface {
+	Notify(ctx context.Context, ev TaskEvent) error
+}
+
+// eventPayload is the JSON form of a TaskEvent used by webhook and command
+// notifiers.
+type eventPayload struct {
+	Event      EventType `json:"event"`
+	TaskID     int       `json:"task_id"`
+	Task       string    `json:"task"`
+	Status     string    `json:"status"`
+	LatencyMS  int64     `json:"latency_ms"`
+	Kind       string    `json:"kind,omitempty"`
+	HTTPStatus int       `json:"http_status,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	Attempts   int       `json:"attempts,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Text       string    `json:"text,omitempty"`
+}
+
+func newEventPayload(ev TaskEvent) eventPayload {
+	p := eventPayload{
+		Event:     ev.Type,
+		TaskID:    ev.TaskID,
+		Task:      ev.Entry,
+		LatencyMS: ev.Latency.Milliseconds(),
+		Text:      ev.Text,
+	}
+	switch ev.Type {
+	case EventStart:
+		p.Status = "running"
+	case EventSuccess:
+		p.Status = "ok"
+	case EventCancel:
+		p.Status = "canceled"
+	case EventSkip:
+		p.Status = "skipped"
+	default:
+		p.Status = "failed"
+	}
+	if f := ev.Failure; f != nil {
+		p.Kind = f.Kind
+		p.HTTPStatus = f.Status
+		p.Message = f.Message
+		p.Attempts = f.Attempts
+		p.Error = f.Error
+	}
+	return p
+}
+
+// LogNotifier writes one key=value line per event.
+type LogNotifier struct {
+	W io.Writer
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, ev TaskEvent) error {
+	w := n.W
+	if w == nil {
+		w = os.Stdout
+	}
+	p := newEventPayload(ev)
+	line := fmt.Sprintf("[event] event=%s task_id=%d task=%q status=%s latency_ms=%d", p.Event, p.TaskID, p.Task, p.Status, p.LatencyMS)
+	if p.Kind != "" {
+		line += fmt.Sprintf(" kind=%s", p.Kind)
+	}
+	if p.HTTPStatus != 0 {
+		line += fmt.Sprintf(" http_status=%d", p.HTTPStatus)
+	}
+	if p.Attempts != 0 {
+		line += fmt.Sprintf(" attempts=%d", p.Attempts)
+	}
+	if p.Error != "" {
+		line += fmt.Sprintf(" error=%q", p.Error)
+	}
+	_, err := fmt.Fprintln(w, line)
+	return err
+}
+
+// WebhookNotifier POSTs the event as JSON to URL.
+type WebhookNotifier struct {
+	URL     string
+	Headers map[string]string
+	Client  netclient.Doer
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, ev TaskEvent) error {
+	body, err := json.Marshal(newEventPayload(ev))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.Headers {
+		req.Header.Set(k, v)
+	}
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// CommandNotifier runs Command with Args rendered as text/templates over the
+// TaskEvent. The event is also passed as JSON on stdin and as STP_* variables.
+type CommandNotifier struct {
+	Command string
+	Args    []*template.Template
+}
+
+func (n *CommandNotifier) Notify(ctx context.Context, ev TaskEvent) error {
+	args := make([]string, 0, len(n.Args))
+	for _, t := range n.Args {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, ev); err != nil {
+			return err
+		}
+		args = append(args, buf.String())
+	}
+	p := newEventPayload(ev)
+	stdin, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, n.Command, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Env = append(os.Environ(),
+		"STP_EVENT="+string(p.Event),
+		fmt.Sprintf("STP_TASK_ID=%d", p.TaskID),
+		"STP_TASK="+p.Task,
+		"STP_STATUS="+p.Status,
+		fmt.Sprintf("STP_LATENCY_MS=%d", p.LatencyMS),
+		"STP_ERROR="+p.Error,
+		"STP_TEXT="+p.Text,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// filteredNotifier limits a notifier to a set of event types. Its events
+// are delivered one at a time, in order, from its own queue.
+type filteredNotifier struct {
+	Notifier
+	events  map[EventType]bool
+	timeout time.Duration
+
+	once  sync.Once
+	queue chan TaskEvent
+}
+
+// notifierQueueSize bounds the events waiting for one notifier; events
+// emitted while its queue is full are dropped.
+const notifierQueueSize = 64
+
+func newFilteredNotifier(n Notifier) *filteredNotifier {
+	return &filteredNotifier{Notifier: n, events: map[EventType]bool{}, timeout: defaultNotifierTimeout}
+}
+
+func (f *filteredNotifier) wants(t EventType) bool {
+	return len(f.events) == 0 || f.events[t]
+}
+
+// stop ends the delivery worker once the queue is empty. The notifier must
+// not be emitted to afterwards.
+func (f *filteredNotifier) stop() {
+	f.once.Do(func() {})
+	if f.queue != nil {
+		close(f.queue)
+	}
+}
+
+const defaultNotifierTimeout = 10 * time.Second
+
+// newNotifiers builds the notifier backends described by cfg. Webhooks are
+// sent with doer, the App's HTTP client, so that they use the configured
+// proxy and TLS settings.
+func newNotifiers(cfgs []config.NotifierConfig, doer netclient.Doer) ([]*filteredNotifier, error) {
+	out := make([]*filteredNotifier, 0, len(cfgs))
+	for i, c := range cfgs {
+		fn := newFilteredNotifier(nil)
+		if c.Timeout > 0 {
+			fn.timeout = time.Duration(c.Timeout) * time.Second
+		}
+		for _, e := range c.Events {
+			t := EventType(strings.ToLower(strings.TrimSpace(e)))
+			switch t {
+			case EventStart, EventSuccess, EventFailure, EventCancel, EventSkip:
+				fn.events[t] = true
+			default:
+				return nil, fmt.Errorf("notifier %d: unknown event %q", i, e)
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(c.Type)) {
+		case "log":
+			fn.Notifier = &LogNotifier{}
+		case "webhook":
+			if strings.TrimSpace(c.URL) == "" {
+				return nil, fmt.Errorf("notifier %d: webhook URL empty", i)
+			}
+			fn.Notifier = &WebhookNotifier{URL: c.URL, Headers: c.Headers, Client: doer}
+		case "command":
+			if strings.TrimSpace(c.Command) == "" {
+				return nil, fmt.Errorf("notifier %d: command empty", i)
+			}
+			cn := &CommandNotifier{Command: c.Command}
+			for j, arg := range c.Args {
+				t, err := template.New(fmt.Sprintf("arg%d", j)).Parse(arg)
+				if err != nil {
+					return nil, fmt.Errorf("notifier %d: %w", i, err)
+				}
+				cn.Args = append(cn.Args, t)
+			}
+			fn.Notifier = cn
+		default:
+			return nil, fmt.Errorf("notifier %d: unknown type %q", i, c.Type)
+		}
+		out = append(out, fn)
+	}
+	return out, nil
+}
+
+// emit queues ev for every interested notifier without blocking the task
+// loop. Each notifier gets its events in order from a single worker.
+func (a *App) emit(ev TaskEvent) {
+	for _, n := range a.notifiers {
+		if !n.wants(ev.Type) {
+			continue
+		}
+		n.once.Do(func() {
+			n.queue = make(chan TaskEvent, notifierQueueSize)
+			go a.deliver(n)
+		})
+		a.notifyWG.Add(1)
+		select {
+		case n.queue <- ev:
+		default:
+			a.notifyWG.Done()
+			if a.cfg.DEBUG {
+				fmt.Printf("[notify] queue full, dropping %s event for task %d\n", ev.Type, ev.TaskID)
+			}
+		}
+	}
+}
+
+// deliver sends the queued events of n until its queue is closed.
+func (a *App) deliver(n *filteredNotifier) {
+	for ev := range n.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+		if err := n.Notify(ctx, ev); err != nil && a.cfg.DEBUG {
+			fmt.Printf("[notify] %s event for task %d failed: %v\n", ev.Type, ev.TaskID, err)
+		}
+		cancel()
+		a.notifyWG.Done()
+	}
+}
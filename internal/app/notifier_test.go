@@ -0,0 +1,256 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"stp/internal/config"
+)
+
+func TestWebhookNotifierReceivesLifecycle(t *testing.T) {
+	var mu sync.Mutex
+	var got []eventPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p eventPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("decode webhook body: %v", err)
+		}
+		if r.Header.Get("X-Team") != "stp" {
+			t.Errorf("missing custom header")
+		}
+		mu.Lock()
+		got = append(got, p)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	cfg := baseConfig()
+	cfg.HotKeyConfig[0].Name = "translate-en"
+	cfg.Notifiers = []config.NotifierConfig{
+		{Type: "webhook", URL: srv.URL, Headers: map[string]string{"X-Team": "stp"}, Events: []string{"start", "failure"}},
+	}
+	ioMock := &fakeTextIO{copyText: "hello"}
+	var hooks int32
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		// Webhooks go through the App's client as well.
+		if strings.HasPrefix(req.URL.String(), srv.URL) {
+			atomic.AddInt32(&hooks, 1)
+			return srv.Client().Do(req)
+		}
+		return &http.Response{StatusCode: 500, Body: io.NopCloser(strings.NewReader(`{"error":"boom"}`))}, nil
+	}}
+	a, err := New(cfg, doer, ioMock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.runTask(1)
+	a.notifyWG.Wait()
+	if atomic.LoadInt32(&hooks) != 2 {
+		t.Fatalf("expected webhooks to use the App's client, got %d", hooks)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 2 || got[0].Event != EventStart || got[1].Event != EventFailure {
+		t.Fatalf("expected start then failure events, got %#v", got)
+	}
+	byEvent := map[EventType]eventPayload{}
+	for _, p := range got {
+		byEvent[p.Event] = p
+	}
+	f, ok := byEvent[EventFailure]
+	if !ok || byEvent[EventStart].Task != "translate-en" {
+		t.Fatalf("unexpected events: %#v", got)
+	}
+	if f.Status != "failed" || f.HTTPStatus != 500 || f.Message != "boom" || f.Kind != FailureStatus || f.Error == "" {
+		t.Fatalf("unexpected failure payload: %#v", f)
+	}
+}
+
+func TestLogNotifierAndCancelEvent(t *testing.T) {
+	cfg := baseConfig()
+	ioMock := &fakeTextIO{copyText: "hello"}
+	started := make(chan struct{})
+	doer := fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		close(started)
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}}
+	a, err := New(cfg, doer, ioMock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	a.AddNotifier(&LogNotifier{W: lockedWriter{&mu, &buf}})
+	rec := &recordingNotifier{}
+	a.AddNotifier(rec)
+
+	go func() {
+		<-started
+		a.StopAll()
+	}()
+	a.runTask(1)
+	a.notifyWG.Wait()
+
+	if types := rec.types(); len(types) != 2 || !containsEvent(types, EventStart) || !containsEvent(types, EventCancel) {
+		t.Fatalf("expected start and cancel events, got %v", types)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !strings.Contains(buf.String(), "event=cancel") || !strings.Contains(buf.String(), "status=canceled") {
+		t.Fatalf("unexpected log lines: %s", buf.String())
+	}
+}
+
+func TestEmptySelectionIsSkipNotFailure(t *testing.T) {
+	cfg := baseConfig()
+	cfg.RequestFailedNotification = true
+	ioMock := &fakeTextIO{copyText: "  "}
+	a, err := New(cfg, fakeDoer{fn: func(req *http.Request) (*http.Response, error) {
+		t.Error("no request expected for an empty selection")
+		return nil, errors.New("unexpected")
+	}}, ioMock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recordingNotifier{}
+	a.AddNotifier(rec)
+	a.runTask(1)
+	a.notifyWG.Wait()
+
+	if types := rec.types(); len(types) != 2 || !containsEvent(types, EventStart) || !containsEvent(types, EventSkip) {
+		t.Fatalf("expected start and skip events, got %v", types)
+	}
+	if len(ioMock.pasted) != 0 {
+		t.Fatalf("nothing should be pasted, got %v", ioMock.pasted)
+	}
+}
+
+type blockingNotifier struct {
+	entered chan struct{}
+	release chan struct{}
+	mu      sync.Mutex
+	n       int
+}
+
+func (b *blockingNotifier) Notify(ctx context.Context, ev TaskEvent) error {
+	b.mu.Lock()
+	b.n++
+	first := b.n == 1
+	b.mu.Unlock()
+	if first {
+		close(b.entered)
+		<-b.release
+	}
+	return nil
+}
+
+func TestNotifierQueueIsBounded(t *testing.T) {
+	a, err := New(baseConfig(), fakeDoer{}, &fakeTextIO{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &blockingNotifier{entered: make(chan struct{}), release: make(chan struct{})}
+	a.AddNotifier(b)
+	a.emit(TaskEvent{Type: EventStart, TaskID: 1})
+	<-b.entered
+	for i := 0; i < notifierQueueSize+5; i++ {
+		a.emit(TaskEvent{Type: EventSuccess, TaskID: 1})
+	}
+	close(b.release)
+	a.notifyWG.Wait()
+	if b.n != 1+notifierQueueSize {
+		t.Fatalf("expected %d delivered events, got %d", 1+notifierQueueSize, b.n)
+	}
+	a.Close()
+}
+
+func TestCommandNotifier(t *testing.T) {
+	if os.Getenv("STP_NOTIFIER_HELPER") != "" {
+		return
+	}
+	out := filepath.Join(t.TempDir(), "out.txt")
+	t.Setenv("STP_NOTIFIER_OUT", out)
+	notifiers, err := newNotifiers([]config.NotifierConfig{{
+		Type:    "command",
+		Command: os.Args[0],
+		Args:    []string{"-test.run=TestCommandNotifierHelper", "--", "{{.Entry}}:{{.Type}}"},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := notifiers[0]
+	t.Setenv("STP_NOTIFIER_HELPER", "1")
+	if err := n.Notify(context.Background(), TaskEvent{Type: EventSuccess, TaskID: 2, Entry: "summary"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "summary:success|success|ok" {
+		t.Fatalf("unexpected helper output: %q", string(b))
+	}
+}
+
+// TestCommandNotifierHelper is the external command run by TestCommandNotifier.
+func TestCommandNotifierHelper(t *testing.T) {
+	if os.Getenv("STP_NOTIFIER_HELPER") == "" {
+		return
+	}
+	var p eventPayload
+	if err := json.NewDecoder(os.Stdin).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	arg := os.Args[len(os.Args)-1]
+	line := fmt.Sprintf("%s|%s|%s", arg, os.Getenv("STP_EVENT"), p.Status)
+	if err := os.WriteFile(os.Getenv("STP_NOTIFIER_OUT"), []byte(line), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewNotifiersValidation(t *testing.T) {
+	bad := [][]config.NotifierConfig{
+		{{Type: "pager"}},
+		{{Type: "webhook"}},
+		{{Type: "log", Events: []string{"finished"}}},
+	}
+	for _, c := range bad {
+		if _, err := newNotifiers(c, nil); err == nil {
+			t.Fatalf("expected error for %#v", c)
+		}
+	}
+}
+
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+func containsEvent(types []EventType, t EventType) bool {
+	for _, x := range types {
+		if x == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"stp/internal/config"
+	"stp/internal/netclient"
+)
+
+// Failure kinds, used as keys of config.FailureNotifications.
+const (
+	FailureStatus      = "status"
+	FailureTimeout     = "timeout"
+	FailureCanceled    = "canceled"
+	FailureTransport   = "transport"
+	FailureUnavailable = "unavailable"
+	FailureEmptyResult = "empty_result"
+	FailureClipboard   = "clipboard"
+)
+
+// Delivery channels of a failure notification.
+const (
+	ChannelPaste     = "paste"
+	ChannelClipboard = "clipboard"
+	ChannelLog       = "log"
+	ChannelNotifier  = "notifier"
+)
+
+var defaultFailureRules = map[string]config.NotificationRule{
+	FailureStatus:      {Template: "[request failed]", Channel: ChannelPaste},
+	FailureTimeout:     {Template: "[request failed]", Channel: ChannelPaste},
+	FailureCanceled:    {Template: "[request failed]", Channel: ChannelPaste},
+	FailureTransport:   {Template: "[request failed]", Channel: ChannelPaste},
+	FailureUnavailable: {Template: "[endpoint unavailable]", Channel: ChannelPaste},
+	FailureEmptyResult: {Template: "[empty result]", Channel: ChannelPaste},
+	FailureClipboard:   {Template: "[clipboard {{.Op}} failed] {{.Error}}", Channel: ChannelLog},
+}
+
+// Failure is the data available to notification templates.
+type Failure struct {
+	Kind     string
+	TaskID   int
+	Entry    string
+	Status   int
+	Message  string
+	Attempts int
+	Op       string
+	Error    string
+	Err      error `json:"-"`
+}
+
+// ClipboardWriter is implemented by TextIO values that can place text on the
+// clipboard without pasting it.
+type ClipboardWriter interface {
+	WriteClipboard(text string) error
+}
+
+type failureRule struct {
+	tmpl    *template.Template
+	channel string
+}
+
+func compileFailureRules(custom map[string]config.NotificationRule) (map[string]failureRule, error) {
+	for kind := range custom {
+		if _, ok := defaultFailureRules[kind]; !ok {
+			return nil, fmt.Errorf("unknown failure kind %q", kind)
+		}
+	}
+	out := make(map[string]failureRule, len(defaultFailureRules))
+	for kind, def := range defaultFailureRules {
+		rule := def
+		if c, ok := custom[kind]; ok {
+			if c.Template != "" {
+				rule.Template = c.Template
+			}
+			if c.Channel != "" {
+				rule.Channel = strings.ToLower(strings.TrimSpace(c.Channel))
+			}
+		}
+		switch rule.Channel {
+		case ChannelPaste, ChannelClipboard, ChannelLog, ChannelNotifier:
+		default:
+			return nil, fmt.Errorf("failure kind %q: unknown channel %q", kind, rule.Channel)
+		}
+		tmpl, err := template.New(kind).Option("missingkey=zero").Parse(rule.Template)
+		if err != nil {
+			return nil, fmt.Errorf("failure kind %q: %w", kind, err)
+		}
+		out[kind] = failureRule{tmpl: tmpl, channel: rule.Channel}
+	}
+	return out, nil
+}
+
+// describeFailure maps a handleTask error to its notification data.
+func describeFailure(id int, entry string, err error) Failure {
+	f := Failure{TaskID: id, Entry: entry, Error: err.Error(), Err: err}
+	var statusErr *netclient.StatusError
+	var timeoutErr *netclient.TimeoutError
+	var canceledErr *netclient.CanceledError
+	var transportErr *netclient.TransportError
+	var circuitErr *netclient.CircuitOpenError
+	var extractErr *ExtractionError
+	var clipErr *ClipboardError
+	switch {
+	// Checked first: it wraps the failure that tripped the circuit.
+	case errors.As(err, &circuitErr):
+		f.Kind = FailureUnavailable
+		f.Message = "circuit open for " + circuitErr.Endpoint
+		f.Attempts = circuitErr.Attempts
+	case errors.As(err, &statusErr):
+		f.Kind = FailureStatus
+		f.Status = statusErr.StatusCode
+		f.Message = statusErr.Message
+		f.Attempts = statusErr.Attempts
+	case errors.As(err, &timeoutErr):
+		f.Kind = FailureTimeout
+		f.Attempts = timeoutErr.Attempts
+	case errors.As(err, &canceledErr):
+		f.Kind = FailureCanceled
+		f.Attempts = canceledErr.Attempts
+	case errors.As(err, &extractErr):
+		f.Kind = FailureEmptyResult
+	case errors.As(err, &clipErr):
+		f.Kind = FailureClipboard
+		f.Op = clipErr.Op
+	case errors.As(err, &transportErr):
+		f.Kind = FailureTransport
+		f.Attempts = transportErr.Attempts
+	default:
+		f.Kind = FailureTransport
+	}
+	return f
+}
+
+// renderFailure returns the notification text for f and its channel.
+func (a *App) renderFailure(f Failure) (string, string) {
+	rule, ok := a.failureRules[f.Kind]
+	if !ok {
+		return "", ""
+	}
+	var buf bytes.Buffer
+	if err := rule.tmpl.Execute(&buf, f); err != nil {
+		if a.cfg.DEBUG {
+			fmt.Printf("[notify] template for %s failed: %v\n", f.Kind, err)
+		}
+		return "", ""
+	}
+	return buf.String(), rule.channel
+}
+
+// deliverFailure sends text through channel. The notifier channel has no
+// local delivery: the failure event is emitted to the notifiers anyway.
+func (a *App) deliverFailure(f Failure, text, channel string) {
+	switch channel {
+	case ChannelPaste:
+		if err := a.textIO.PasteText(text); err != nil && a.cfg.DEBUG {
+			fmt.Printf("[notify] paste failed: %v\n", err)
+		}
+	case ChannelClipboard:
+		w, ok := a.textIO.(ClipboardWriter)
+		if !ok {
+			if a.cfg.DEBUG {
+				fmt.Printf("[notify] clipboard channel not supported\n")
+			}
+			return
+		}
+		if err := w.WriteClipboard(text); err != nil && a.cfg.DEBUG {
+			fmt.Printf("[notify] clipboard write failed: %v\n", err)
+		}
+	case ChannelLog:
+		fmt.Printf("[notify] task=%d entry=%s kind=%s: %s\n", f.TaskID, f.Entry, f.Kind, text)
+	}
+}
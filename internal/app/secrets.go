@@ -0,0 +1,52 @@
+package app
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"stp/internal/secret"
+)
+
+// secretResolver decrypts "secret:<name>" config values from the encrypted
+// secrets file. The file is opened on first use and values are decrypted
+// each time a request is built, so plaintext secrets are never kept in the
+// config or written anywhere.
+type secretResolver struct {
+	path    string
+	keyFile string
+
+	once  sync.Once
+	store *secret.Store
+	err   error
+}
+
+// resolve returns v, or the secret it references.
+func (r *secretResolver) resolve(v string) (string, error) {
+	name, ok := strings.CutPrefix(strings.TrimSpace(v), secret.Prefix)
+	if !ok {
+		return v, nil
+	}
+	r.once.Do(func() {
+		r.store, r.err = secret.Open(r.path, secret.Options{
+			Passphrase: os.Getenv(secret.PassphraseEnv),
+			KeyFile:    r.keyFile,
+		})
+	})
+	if r.err != nil {
+		return "", r.err
+	}
+	return r.store.Get(strings.TrimSpace(name))
+}
+
+// resolveHeaders replaces secret references in header values in place.
+func (r *secretResolver) resolveHeaders(h map[string]string) error {
+	for k, v := range h {
+		resolved, err := r.resolve(v)
+		if err != nil {
+			return err
+		}
+		h[k] = resolved
+	}
+	return nil
+}
@@ -99,3 +99,14 @@ func (m *Manager) PasteText(text string) error {
 	}
 	return fmt.Errorf("failed to write clipboard")
 }
+
+func (m *Manager) WriteClipboard(text string) error {
+	var err error
+	for i := 0; i < 5; i++ {
+		if err = m.Clipboard.WriteAll(text); err == nil {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("failed to write clipboard: %w", err)
+}
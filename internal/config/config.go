@@ -1,54 +1,397 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+// ExtraJSON holds an ExtraConfig or ExtraPatch value. In config files it may
+// be written either as an escaped JSON string (the original format) or as a
+// plain JSON object/array; both are normalized to the compact JSON text.
+type ExtraJSON string
+
+func (e *ExtraJSON) UnmarshalJSON(data []byte) error {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")):
+		*e = ""
+	case trimmed[0] == '"':
+		var s string
+		if err := json.Unmarshal(trimmed, &s); err != nil {
+			return err
+		}
+		*e = ExtraJSON(s)
+	case trimmed[0] == '{' || trimmed[0] == '[':
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, trimmed); err != nil {
+			return err
+		}
+		*e = ExtraJSON(buf.String())
+	default:
+		return fmt.Errorf("expected a JSON object, array or string, got %s", trimmed)
+	}
+	return nil
+}
+
+func (e ExtraJSON) MarshalJSON() ([]byte, error) {
+	s := strings.TrimSpace(string(e))
+	if (strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[")) && json.Valid([]byte(s)) {
+		return []byte(s), nil
+	}
+	return json.Marshal(string(e))
+}
+
+// TokenList holds a Token setting: a single key or, in config files, a JSON
+// array of keys that are rotated (see TokenRotation). Arrays are kept as
+// their compact JSON text.
+type TokenList string
+
+// NewTokenList returns the TokenList of keys.
+func NewTokenList(keys ...string) TokenList {
+	if len(keys) == 1 {
+		return TokenList(keys[0])
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(keys)
+	return TokenList(b)
+}
+
+func (t *TokenList) UnmarshalJSON(data []byte) error {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")):
+		*t = ""
+	case trimmed[0] == '"':
+		var s string
+		if err := json.Unmarshal(trimmed, &s); err != nil {
+			return err
+		}
+		*t = TokenList(s)
+	case trimmed[0] == '[':
+		var keys []string
+		if err := json.Unmarshal(trimmed, &keys); err != nil {
+			return fmt.Errorf("expected a token or an array of tokens: %w", err)
+		}
+		b, _ := json.Marshal(keys)
+		*t = TokenList(b)
+	default:
+		return fmt.Errorf("expected a token or an array of tokens, got %s", trimmed)
+	}
+	return nil
+}
+
+func (t TokenList) MarshalJSON() ([]byte, error) {
+	if keys := t.Keys(); len(keys) > 1 {
+		return json.Marshal(keys)
+	}
+	return json.Marshal(t.First())
+}
+
+// Keys returns the non-empty keys in order.
+func (t TokenList) Keys() []string {
+	s := strings.TrimSpace(string(t))
+	var raw []string
+	if !strings.HasPrefix(s, "[") || json.Unmarshal([]byte(s), &raw) != nil {
+		raw = []string{s}
+	}
+	var keys []string
+	for _, k := range raw {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// First returns the first key, or "" when there is none.
+func (t TokenList) First() string {
+	if keys := t.Keys(); len(keys) > 0 {
+		return keys[0]
+	}
+	return ""
+}
+
+// Example is one few-shot exchange sent between the prompt and the selection.
+type Example struct {
+	User      string `json:"User"`
+	Assistant string `json:"Assistant"`
+}
+
+// HotKeyEntry is one prompt/hotkey pair. The optional fields override the
+// global value of the same name for this entry only; nil or empty means
+// "inherit from the global config".
 type HotKeyEntry struct {
-	Prompt      string `json:"Prompt"`
-	HotKey      string `json:"HotKey"`
-	ExtraConfig string `json:"ExtraConfig"`
+	Name        string    `json:"Name,omitempty"`
+	Prompt      string    `json:"Prompt"`
+	HotKey      string    `json:"HotKey"`
+	ExtraConfig ExtraJSON `json:"ExtraConfig"`
+	ExtraPatch  ExtraJSON `json:"ExtraPatch,omitempty"`
+
+	APIEndpoint    string    `json:"APIEndpoint,omitempty"`
+	Token          TokenList `json:"Token,omitempty"`
+	TokenEnv       string    `json:"TokenEnv,omitempty"`
+	TokenFile      string    `json:"TokenFile,omitempty"`
+	Model          string    `json:"Model,omitempty"`
+	Temperature    *float64  `json:"Temperature,omitempty"`
+	MaxTokens      *int      `json:"Max_Tokens,omitempty"`
+	TEXTPath       string    `json:"TEXTPath,omitempty"`
+	RequestTimeout *int      `json:"RequestTimeout,omitempty"`
+	MaxRetry       *int      `json:"MaxRetry,omitempty"`
+	SystemRole     string    `json:"SystemRole,omitempty"`
+	Proxy          string    `json:"Proxy,omitempty"`
+	NoProxy        string    `json:"NoProxy,omitempty"`
+	AuthScheme     string    `json:"AuthScheme,omitempty"`
+	AuthParam      string    `json:"AuthParam,omitempty"`
+	RequestFormat  string    `json:"RequestFormat,omitempty"`
+
+	ConnectTimeout        *float64 `json:"ConnectTimeout,omitempty"`
+	ResponseHeaderTimeout *float64 `json:"ResponseHeaderTimeout,omitempty"`
+	StreamIdleTimeout     *float64 `json:"StreamIdleTimeout,omitempty"`
+	TaskTimeout           *float64 `json:"TaskTimeout,omitempty"`
+
+	// Headers are merged over the global Headers; an empty value removes
+	// an inherited header.
+	Headers map[string]string `json:"Headers,omitempty"`
+	// Endpoints replaces the global pool; an entry APIEndpoint also opts out
+	// of the global pool.
+	Endpoints        []EndpointConfig `json:"Endpoints,omitempty"`
+	EndpointStrategy string           `json:"EndpointStrategy,omitempty"`
+
+	// OAuth2 and SigV4 replace the global settings of the same name.
+	OAuth2 *OAuth2Config `json:"OAuth2,omitempty"`
+	SigV4  *SigV4Config  `json:"SigV4,omitempty"`
+
+	Examples []Example `json:"Examples,omitempty"`
+
+	// Fallbacks are tried in order after the request failed.
+	Fallbacks []FallbackConfig `json:"Fallbacks,omitempty"`
+	// FanOut sends the request to several models at once.
+	FanOut *FanOutConfig `json:"FanOut,omitempty"`
+}
+
+// ModelOverride replaces the model related fields of an entry for one
+// fallback step or fan-out target. Empty fields keep the entry's value and
+// ExtraConfig is merged over the entry's. AuthScheme replaces the entry's
+// scheme and AuthParam together, like in an entry.
+type ModelOverride struct {
+	Model         string    `json:"Model,omitempty"`
+	APIEndpoint   string    `json:"APIEndpoint,omitempty"`
+	Token         string    `json:"Token,omitempty"`
+	TEXTPath      string    `json:"TEXTPath,omitempty"`
+	RequestFormat string    `json:"RequestFormat,omitempty"`
+	AuthScheme    string    `json:"AuthScheme,omitempty"`
+	AuthParam     string    `json:"AuthParam,omitempty"`
+	ExtraConfig   ExtraJSON `json:"ExtraConfig,omitempty"`
+}
+
+// FallbackConfig is one step of an entry's fallback chain. The step runs
+// only when the previous failure matches one of OnStatus, OnError (regular
+// expressions, case-insensitive) or OnEmptyResult; without any condition it
+// runs on every request or extraction failure.
+type FallbackConfig struct {
+	ModelOverride
+
+	OnStatus      []int    `json:"OnStatus,omitempty"`
+	OnError       []string `json:"OnError,omitempty"`
+	OnEmptyResult bool     `json:"OnEmptyResult,omitempty"`
+}
+
+// FanOutConfig asks the entry's model and every target concurrently. Mode
+// "first" (default) pastes the first successful answer and cancels the
+// rest; "all" waits for every answer and pastes them combined through
+// Template.
+type FanOutConfig struct {
+	Mode     string         `json:"Mode,omitempty"`
+	Targets  []FanOutTarget `json:"Targets"`
+	Template string         `json:"Template,omitempty"`
+}
+
+// FanOutTarget is one additional model of a fan-out. Label defaults to the
+// model name.
+type FanOutTarget struct {
+	Label string `json:"Label,omitempty"`
+	ModelOverride
+}
+
+// OAuth2Config configures the OAuth2 client credentials grant used instead
+// of a static Token. AuthStyle is basic (default) or body.
+type OAuth2Config struct {
+	TokenURL     string            `json:"TokenURL"`
+	ClientID     string            `json:"ClientID"`
+	ClientSecret string            `json:"ClientSecret"`
+	Scopes       []string          `json:"Scopes,omitempty"`
+	Params       map[string]string `json:"Params,omitempty"`
+	AuthStyle    string            `json:"AuthStyle,omitempty"`
+}
+
+// SigV4Config configures AWS Signature Version 4 signing (AuthScheme
+// "sigv4"). Empty credentials and region fall back to the AWS_* environment
+// variables; Service defaults to bedrock.
+type SigV4Config struct {
+	Region          string `json:"Region,omitempty"`
+	Service         string `json:"Service,omitempty"`
+	AccessKeyID     string `json:"AccessKeyID,omitempty"`
+	SecretAccessKey string `json:"SecretAccessKey,omitempty"`
+	SessionToken    string `json:"SessionToken,omitempty"`
+}
+
+// EndpointConfig is one member of an endpoint pool. An empty Token inherits
+// the entry/global Token; lower Priority values are preferred.
+type EndpointConfig struct {
+	URL            string `json:"URL"`
+	Token          string `json:"Token,omitempty"`
+	Priority       int    `json:"Priority,omitempty"`
+	HealthCheckURL string `json:"HealthCheckURL,omitempty"`
+}
+
+// ModelProfile describes how request parameters must be adapted for models
+// whose name matches Match (a case-insensitive glob such as "o1*").
+type ModelProfile struct {
+	Match      string            `json:"Match"`
+	Rename     map[string]string `json:"Rename,omitempty"`
+	Drop       []string          `json:"Drop,omitempty"`
+	SystemRole string            `json:"SystemRole,omitempty"`
+}
+
+// NotificationRule controls how one kind of task failure is reported.
+// Template is a Go text/template; Channel is paste, clipboard, log or notifier.
+type NotificationRule struct {
+	Template string `json:"Template,omitempty"`
+	Channel  string `json:"Channel,omitempty"`
+}
+
+// NotifierConfig configures one task event notifier backend.
+// Type is log, webhook or command; Events filters start, success, failure,
+// cancel and skip (empty means all). Timeout is in seconds.
+type NotifierConfig struct {
+	Type    string            `json:"Type"`
+	Events  []string          `json:"Events,omitempty"`
+	URL     string            `json:"URL,omitempty"`
+	Headers map[string]string `json:"Headers,omitempty"`
+	Command string            `json:"Command,omitempty"`
+	Args    []string          `json:"Args,omitempty"`
+	Timeout int               `json:"Timeout,omitempty"`
 }
 
 type Config struct {
-	APIEndpoint               string        `json:"APIEndpoint"`
-	Token                     string        `json:"Token"`
-	Model                     string        `json:"Model"`
-	Temperature               float64       `json:"Temperature"`
-	MaxTokens                 int           `json:"Max_Tokens"`
-	TEXTPath                  string        `json:"TEXTPath"`
-	ExtraConfig               string        `json:"ExtraConfig"`
-	RequestTimeout            int           `json:"RequestTimeout"`
-	MaxRetry                  int           `json:"MaxRetry"`
-	RetryBaseDelay            float64       `json:"RetryBaseDelay"`
-	EnableHTTP2               bool          `json:"EnableHTTP2"`
-	VerifySSL                 bool          `json:"VerifySSL"`
-	ClipboardTimeout          int           `json:"ClipboardTimeout"`
-	RequestFailedNotification bool          `json:"RequestFailedNotification"`
-	StopTaskHotkey            string        `json:"StopTaskHotkey"`
-	HotKeyConfig              []HotKeyEntry `json:"HotKeyConfig"`
-	HotKeyHook                bool          `json:"HotKeyHook"`
-	DEBUG                     bool          `json:"DEBUG"`
+	APIEndpoint               string                      `json:"APIEndpoint"`
+	Token                     TokenList                   `json:"Token"`
+	TokenEnv                  string                      `json:"TokenEnv,omitempty"`
+	TokenFile                 string                      `json:"TokenFile,omitempty"`
+	SecretsFile               string                      `json:"SecretsFile"`
+	SecretKeyFile             string                      `json:"SecretKeyFile,omitempty"`
+	TokenRotation             string                      `json:"TokenRotation"`
+	TokenBenchDuration        float64                     `json:"TokenBenchDuration"`
+	Model                     string                      `json:"Model"`
+	Temperature               float64                     `json:"Temperature"`
+	MaxTokens                 int                         `json:"Max_Tokens"`
+	TEXTPath                  string                      `json:"TEXTPath"`
+	ExtraConfig               ExtraJSON                   `json:"ExtraConfig"`
+	ExtraPatch                ExtraJSON                   `json:"ExtraPatch,omitempty"`
+	RequestTimeout            int                         `json:"RequestTimeout"`
+	ConnectTimeout            float64                     `json:"ConnectTimeout"`
+	TLSHandshakeTimeout       float64                     `json:"TLSHandshakeTimeout"`
+	ResponseHeaderTimeout     float64                     `json:"ResponseHeaderTimeout"`
+	StreamIdleTimeout         float64                     `json:"StreamIdleTimeout"`
+	TaskTimeout               float64                     `json:"TaskTimeout"`
+	MaxRetry                  int                         `json:"MaxRetry"`
+	RetryBaseDelay            float64                     `json:"RetryBaseDelay"`
+	RetryMaxDelay             float64                     `json:"RetryMaxDelay"`
+	RetryDeadline             float64                     `json:"RetryDeadline"`
+	RetryJitter               bool                        `json:"RetryJitter"`
+	RetryableStatusCodes      []int                       `json:"RetryableStatusCodes"`
+	RetryOnNetworkError       bool                        `json:"RetryOnNetworkError"`
+	RetryOnTimeout            bool                        `json:"RetryOnTimeout"`
+	EnableHTTP2               bool                        `json:"EnableHTTP2"`
+	VerifySSL                 bool                        `json:"VerifySSL"`
+	CAFiles                   []string                    `json:"CAFiles,omitempty"`
+	ClientCertFile            string                      `json:"ClientCertFile,omitempty"`
+	ClientKeyFile             string                      `json:"ClientKeyFile,omitempty"`
+	PinnedPublicKeys          []string                    `json:"PinnedPublicKeys,omitempty"`
+	MinTLSVersion             string                      `json:"MinTLSVersion,omitempty"`
+	Proxy                     string                      `json:"Proxy"`
+	NoProxy                   string                      `json:"NoProxy"`
+	Headers                   map[string]string           `json:"Headers,omitempty"`
+	AuthScheme                string                      `json:"AuthScheme"`
+	AuthParam                 string                      `json:"AuthParam,omitempty"`
+	UserAgent                 string                      `json:"UserAgent"`
+	OAuth2                    *OAuth2Config               `json:"OAuth2,omitempty"`
+	SigV4                     *SigV4Config                `json:"SigV4,omitempty"`
+	RequestFormat             string                      `json:"RequestFormat"`
+	Endpoints                 []EndpointConfig            `json:"Endpoints,omitempty"`
+	EndpointStrategy          string                      `json:"EndpointStrategy"`
+	EndpointFailureThreshold  int                         `json:"EndpointFailureThreshold"`
+	EndpointCooldown          float64                     `json:"EndpointCooldown"`
+	HealthCheckInterval       float64                     `json:"HealthCheckInterval"`
+	RateLimitRPM              float64                     `json:"RateLimitRPM"`
+	RateLimitTPM              float64                     `json:"RateLimitTPM"`
+	RateLimitHeaders          bool                        `json:"RateLimitHeaders"`
+	CircuitBreakerThreshold   int                         `json:"CircuitBreakerThreshold"`
+	CircuitBreakerCooldown    float64                     `json:"CircuitBreakerCooldown"`
+	ClipboardTimeout          int                         `json:"ClipboardTimeout"`
+	RequestFailedNotification bool                        `json:"RequestFailedNotification"`
+	FailureNotifications      map[string]NotificationRule `json:"FailureNotifications,omitempty"`
+	Notifiers                 []NotifierConfig            `json:"Notifiers,omitempty"`
+	StopTaskHotkey            string                      `json:"StopTaskHotkey"`
+	SystemRole                string                      `json:"SystemRole"`
+	ModelProfiles             []ModelProfile              `json:"ModelProfiles,omitempty"`
+	PromptsDir                string                      `json:"PromptsDir,omitempty"`
+	HotKeyConfig              []HotKeyEntry               `json:"HotKeyConfig"`
+	HotKeyHook                bool                        `json:"HotKeyHook"`
+	DEBUG                     bool                        `json:"DEBUG"`
 }
 
 func Default() Config {
 	return Config{
 		APIEndpoint:               "",
 		Token:                     "",
+		TokenRotation:             "round-robin",
+		TokenBenchDuration:        60,
+		SecretsFile:               "secrets.json",
 		Model:                     "",
 		Temperature:               0.0,
 		MaxTokens:                 0,
 		TEXTPath:                  "choices[0].message.content",
 		ExtraConfig:               "",
 		RequestTimeout:            30,
+		ConnectTimeout:            10,
+		TLSHandshakeTimeout:       10,
+		ResponseHeaderTimeout:     0,
+		StreamIdleTimeout:         0,
+		TaskTimeout:               0,
 		MaxRetry:                  3,
 		RetryBaseDelay:            0.5,
+		RetryMaxDelay:             30,
+		RetryDeadline:             0,
+		RetryJitter:               true,
+		RetryableStatusCodes:      []int{408, 425, 429, 500, 502, 503, 504},
+		RetryOnNetworkError:       true,
+		RetryOnTimeout:            true,
 		EnableHTTP2:               true,
 		VerifySSL:                 true,
+		Proxy:                     "",
+		NoProxy:                   "",
+		AuthScheme:                "bearer",
+		UserAgent:                 "clip-hotkey-client/1.0",
+		RequestFormat:             "openai",
+		EndpointStrategy:          "priority",
+		EndpointFailureThreshold:  1,
+		EndpointCooldown:          30,
+		HealthCheckInterval:       0,
+		RateLimitRPM:              0,
+		RateLimitTPM:              0,
+		CircuitBreakerThreshold:   5,
+		CircuitBreakerCooldown:    30,
 		ClipboardTimeout:          1000,
 		RequestFailedNotification: false,
 		StopTaskHotkey:            "",
+		SystemRole:                "",
 		HotKeyConfig: []HotKeyEntry{
 			{Prompt: "", HotKey: "ctrl+f1", ExtraConfig: ""},
 			{Prompt: "", HotKey: "ctrl+f2", ExtraConfig: ""},
@@ -66,19 +409,92 @@ func Default() Config {
 	}
 }
 
+// Load reads the config file at path over the defaults. The file may be
+// JSON, YAML or TOML (see FormatOf); all three map to the same fields.
+// ${...} references in string values are interpolated (see Interpolate)
+// and TokenEnv and TokenFile are resolved; relative file paths, including
+// SecretsFile, SecretKeyFile, PromptsDir and the TLS files, are relative to
+// the config file. The prompt files of PromptsDir are merged into HotKeyConfig.
+//
+// Files named by Extends and Include are layered with the file (see
+// mergeLayers), so that a shared base config can be extended by a personal
+// one.
 func Load(path string) (Config, error) {
+	cfg, _, err := LoadWithOrigins(path)
+	return cfg, err
+}
+
+// LoadWithOrigins is Load that also reports the file each setting comes
+// from.
+func LoadWithOrigins(path string) (Config, Origins, error) {
 	cfg := Default()
+	origins := Origins{}
 	if path == "" {
-		return cfg, nil
+		return cfg, origins, nil
 	}
-	data, err := os.ReadFile(path)
+	layers, err := readLayers(path, true, nil, nil)
 	if err != nil {
-		return cfg, err
+		return cfg, origins, err
+	}
+	doc, err := mergeLayers(layers, origins)
+	if err != nil {
+		return cfg, origins, err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return cfg, origins, err
 	}
 	if err := json.Unmarshal(data, &cfg); err != nil {
-		return cfg, err
+		return cfg, origins, err
+	}
+	dir := filepath.Dir(path)
+	if err := cfg.loadPrompts(dir, origins); err != nil {
+		return cfg, origins, err
+	}
+	if err := cfg.resolveTokenRefs(dir); err != nil {
+		return cfg, origins, err
+	}
+	cfg.SecretsFile = relativeTo(dir, cfg.SecretsFile)
+	cfg.SecretKeyFile = relativeTo(dir, cfg.SecretKeyFile)
+	cfg.ClientCertFile = relativeTo(dir, cfg.ClientCertFile)
+	cfg.ClientKeyFile = relativeTo(dir, cfg.ClientKeyFile)
+	for i, f := range cfg.CAFiles {
+		cfg.CAFiles[i] = relativeTo(dir, f)
+	}
+	return cfg, origins, nil
+}
+
+// LoadSecretPaths returns the SecretsFile and SecretKeyFile of the config
+// file at path, layered and resolved like Load. Other settings are not
+// interpolated or validated, so that a broken reference elsewhere in the
+// config does not prevent managing secrets.
+func LoadSecretPaths(path string) (secretsFile, keyFile string, err error) {
+	cfg := Default()
+	layers, err := readLayers(path, true, nil, map[string]bool{"SecretsFile": true, "SecretKeyFile": true})
+	if err != nil {
+		return "", "", err
+	}
+	doc, err := mergeLayers(layers, nil)
+	if err != nil {
+		return "", "", err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return "", "", err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return "", "", err
+	}
+	dir := filepath.Dir(path)
+	return relativeTo(dir, cfg.SecretsFile), relativeTo(dir, cfg.SecretKeyFile), nil
+}
+
+// relativeTo resolves a relative, non-empty path against dir.
+func relativeTo(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
 	}
-	return cfg, nil
+	return filepath.Join(dir, path)
 }
 
 func SaveDefault(path string) error {
@@ -88,3 +504,113 @@ func SaveDefault(path string) error {
 	}
 	return os.WriteFile(path, b, 0o644)
 }
+
+// EntrySettings are the effective request settings of one HotKeyConfig entry.
+type EntrySettings struct {
+	APIEndpoint    string
+	Token          string
+	Tokens         []string
+	Model          string
+	Temperature    float64
+	MaxTokens      int
+	TEXTPath       string
+	RequestTimeout int
+	MaxRetry       int
+	SystemRole     string
+	Proxy          string
+	NoProxy        string
+	AuthScheme     string
+	AuthParam      string
+	UserAgent      string
+	RequestFormat  string
+
+	ConnectTimeout        float64
+	ResponseHeaderTimeout float64
+	StreamIdleTimeout     float64
+	TaskTimeout           float64
+}
+
+// EntrySettings resolves the settings for e with the precedence
+// entry > global config > defaults (the global config already carries the defaults).
+func (c Config) EntrySettings(e HotKeyEntry) EntrySettings {
+	s := EntrySettings{
+		APIEndpoint:    strings.TrimSpace(c.APIEndpoint),
+		Token:          c.Token.First(),
+		Tokens:         c.Token.Keys(),
+		Model:          c.Model,
+		Temperature:    c.Temperature,
+		MaxTokens:      c.MaxTokens,
+		TEXTPath:       strings.TrimSpace(c.TEXTPath),
+		RequestTimeout: c.RequestTimeout,
+		MaxRetry:       c.MaxRetry,
+		SystemRole:     strings.TrimSpace(c.SystemRole),
+		Proxy:          strings.TrimSpace(c.Proxy),
+		NoProxy:        strings.TrimSpace(c.NoProxy),
+		AuthScheme:     strings.TrimSpace(c.AuthScheme),
+		AuthParam:      strings.TrimSpace(c.AuthParam),
+		UserAgent:      strings.TrimSpace(c.UserAgent),
+		RequestFormat:  strings.TrimSpace(c.RequestFormat),
+
+		ConnectTimeout:        c.ConnectTimeout,
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+		StreamIdleTimeout:     c.StreamIdleTimeout,
+		TaskTimeout:           c.TaskTimeout,
+	}
+	if v := strings.TrimSpace(e.APIEndpoint); v != "" {
+		s.APIEndpoint = v
+	}
+	if keys := e.Token.Keys(); len(keys) > 0 {
+		s.Token, s.Tokens = keys[0], keys
+	}
+	if v := strings.TrimSpace(e.Model); v != "" {
+		s.Model = v
+	}
+	if e.Temperature != nil {
+		s.Temperature = *e.Temperature
+	}
+	if e.MaxTokens != nil {
+		s.MaxTokens = *e.MaxTokens
+	}
+	if v := strings.TrimSpace(e.TEXTPath); v != "" {
+		s.TEXTPath = v
+	}
+	if e.RequestTimeout != nil {
+		s.RequestTimeout = *e.RequestTimeout
+	}
+	if e.MaxRetry != nil {
+		s.MaxRetry = *e.MaxRetry
+	}
+	if v := strings.TrimSpace(e.SystemRole); v != "" {
+		s.SystemRole = v
+	}
+	if e.ConnectTimeout != nil {
+		s.ConnectTimeout = *e.ConnectTimeout
+	}
+	if e.ResponseHeaderTimeout != nil {
+		s.ResponseHeaderTimeout = *e.ResponseHeaderTimeout
+	}
+	if e.StreamIdleTimeout != nil {
+		s.StreamIdleTimeout = *e.StreamIdleTimeout
+	}
+	if e.TaskTimeout != nil {
+		s.TaskTimeout = *e.TaskTimeout
+	}
+	if v := strings.TrimSpace(e.RequestFormat); v != "" {
+		s.RequestFormat = v
+	}
+	// An entry auth scheme replaces the global scheme and its parameter together.
+	if v := strings.TrimSpace(e.AuthScheme); v != "" {
+		s.AuthScheme = v
+		s.AuthParam = strings.TrimSpace(e.AuthParam)
+	} else if v := strings.TrimSpace(e.AuthParam); v != "" {
+		s.AuthParam = v
+	}
+	// An entry proxy replaces the global proxy and its bypass list together.
+	if v := strings.TrimSpace(e.Proxy); v != "" {
+		s.Proxy = v
+		s.NoProxy = strings.TrimSpace(e.NoProxy)
+	} else if v := strings.TrimSpace(e.NoProxy); v != "" {
+		s.NoProxy = v
+	}
+	return s
+}
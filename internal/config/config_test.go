@@ -2,8 +2,11 @@ package config
 
 import (
 	"bytes"
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -37,7 +40,9 @@ func TestLoadAndCLIOverride(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	ApplyCLI(&cfg, opts)
+	if err := ApplyCLI(&cfg, opts); err != nil {
+		t.Fatal(err)
+	}
 	if !cfg.RequestFailedNotification {
 		t.Fatalf("cli should override RequestFailedNotification=true")
 	}
@@ -45,3 +50,583 @@ func TestLoadAndCLIOverride(t *testing.T) {
 		t.Fatalf("cli should override StopTaskHotkey")
 	}
 }
+
+func TestLoadExtraConfigStringOrObject(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	content := `{
+  "ExtraConfig": "{\"verbosity\":\"low\"}",
+  "HotKeyConfig": [
+    {"Prompt": "a", "HotKey": "ctrl+f1", "ExtraConfig": {"response_format": {"type": "json_object"}}},
+    {"Prompt": "b", "HotKey": "ctrl+f2", "ExtraConfig": null}
+  ]
+}`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ExtraConfig != `{"verbosity":"low"}` {
+		t.Fatalf("unexpected global ExtraConfig: %s", cfg.ExtraConfig)
+	}
+	if cfg.HotKeyConfig[0].ExtraConfig != `{"response_format":{"type":"json_object"}}` {
+		t.Fatalf("unexpected entry ExtraConfig: %s", cfg.HotKeyConfig[0].ExtraConfig)
+	}
+	if cfg.HotKeyConfig[1].ExtraConfig != "" {
+		t.Fatalf("null ExtraConfig should be empty")
+	}
+}
+
+func TestEntrySettingsPrecedence(t *testing.T) {
+	cfg := Default()
+	cfg.APIEndpoint = "https://global"
+	cfg.Model = "global-model"
+	cfg.Temperature = 0.7
+	cfg.MaxTokens = 100
+
+	zero := 0.0
+	maxTokens := 0
+	retries := 5
+	s := cfg.EntrySettings(HotKeyEntry{Model: "entry-model", Temperature: &zero, MaxTokens: &maxTokens, MaxRetry: &retries})
+	if s.APIEndpoint != "https://global" || s.Model != "entry-model" {
+		t.Fatalf("unexpected endpoint/model: %#v", s)
+	}
+	if s.Temperature != 0 || s.MaxTokens != 0 || s.MaxRetry != 5 {
+		t.Fatalf("explicit zero entry values should override global: %#v", s)
+	}
+	if s.RequestTimeout != 30 || s.TEXTPath != "choices[0].message.content" {
+		t.Fatalf("defaults should be inherited: %#v", s)
+	}
+}
+
+func TestEntrySettingsSystemRole(t *testing.T) {
+	cfg := Default()
+	if s := cfg.EntrySettings(HotKeyEntry{}); s.SystemRole != "" {
+		t.Fatalf("default SystemRole should be empty (auto), got %q", s.SystemRole)
+	}
+	cfg.SystemRole = "system"
+	if s := cfg.EntrySettings(HotKeyEntry{}); s.SystemRole != "system" {
+		t.Fatalf("global SystemRole should be inherited")
+	}
+	if s := cfg.EntrySettings(HotKeyEntry{SystemRole: "none"}); s.SystemRole != "none" {
+		t.Fatalf("entry SystemRole should override global")
+	}
+}
+
+func TestCLIHeadersAndAuth(t *testing.T) {
+	cfg := Default()
+	cfg.Headers = map[string]string{"X-Keep": "1"}
+	var stderr bytes.Buffer
+	opts, err := ParseCLI([]string{"-header", "X-Tenant: acme", "-header=X-Trace:on", "-auth-scheme", "header", "-auth-param", "X-Api-Key"}, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyCLI(&cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Headers["X-Tenant"] != "acme" || cfg.Headers["X-Trace"] != "on" || cfg.Headers["X-Keep"] != "1" {
+		t.Fatalf("unexpected headers: %v", cfg.Headers)
+	}
+	s := cfg.EntrySettings(HotKeyEntry{AuthScheme: "query"})
+	if s.AuthScheme != "query" || s.AuthParam != "" {
+		t.Fatalf("entry AuthScheme should replace the global scheme and param, got %+v", s)
+	}
+
+	opts, _ = ParseCLI([]string{"-header", "no-colon"}, &stderr)
+	if err := ApplyCLI(&cfg, opts); err == nil {
+		t.Fatal("expected malformed -header to be rejected")
+	}
+}
+
+func TestTokenListStringOrArray(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	content := `{"Token":["k1"," k2 ",""],"HotKeyConfig":[{"Prompt":"a","Token":"solo"},{"Prompt":"b"}]}`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := cfg.Token.Keys(); len(keys) != 2 || keys[0] != "k1" || keys[1] != "k2" {
+		t.Fatalf("unexpected global keys %q", keys)
+	}
+	if s := cfg.EntrySettings(cfg.HotKeyConfig[0]); s.Token != "solo" || len(s.Tokens) != 1 {
+		t.Fatalf("entry token should replace the global list, got %+v", s.Tokens)
+	}
+	if s := cfg.EntrySettings(cfg.HotKeyConfig[1]); s.Token != "k1" || len(s.Tokens) != 2 {
+		t.Fatalf("entry should inherit the global list, got %q", s.Tokens)
+	}
+	if b, _ := json.Marshal(cfg.Token); string(b) != `["k1","k2"]` {
+		t.Fatalf("unexpected marshaled list %s", b)
+	}
+	if b, _ := json.Marshal(cfg.HotKeyConfig[0].Token); string(b) != `"solo"` {
+		t.Fatalf("unexpected marshaled token %s", b)
+	}
+
+	var stderr bytes.Buffer
+	opts, err := ParseCLI([]string{"-tokens", "c1, c2", "-token-rotation", "failover"}, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyCLI(&cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	if keys := cfg.Token.Keys(); len(keys) != 2 || keys[1] != "c2" || cfg.TokenRotation != "failover" {
+		t.Fatalf("unexpected cli keys %q / %s", keys, cfg.TokenRotation)
+	}
+
+	// -token is a single key even when it contains a comma.
+	opts, err = ParseCLI([]string{"-token", "user,secret"}, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyCLI(&cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	if keys := cfg.Token.Keys(); len(keys) != 1 || keys[0] != "user,secret" {
+		t.Fatalf("unexpected single cli key %q", keys)
+	}
+	opts, _ = ParseCLI([]string{"-token", "a", "-tokens", "b,c"}, &stderr)
+	if err := ApplyCLI(&cfg, opts); err == nil {
+		t.Fatal("expected -token with -tokens to be rejected")
+	}
+}
+
+func TestLoadInterpolatesSecrets(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "key.txt"), []byte("sk-from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "keys.txt"), []byte("# team keys\nk1\n\nk2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("STP_TEST_HOST", "api.example")
+	t.Setenv("STP_TEST_ORG", "acme")
+	t.Setenv("STP_TEST_KEYS", "e1,e2")
+	path := filepath.Join(dir, "config.json")
+	content := `{
+		"APIEndpoint": "https://${STP_TEST_HOST}/v1",
+		"Token": "${file:key.txt}",
+		"Headers": {"X-Org": "${env:STP_TEST_ORG}", "X-Literal": "$${STP_TEST_ORG}"},
+		"ExtraConfig": {"user": "${STP_TEST_ORG}"},
+		"HotKeyConfig": [
+			{"Prompt": "keep ${STP_TEST_ORG}", "TokenFile": "keys.txt"},
+			{"Prompt": "b", "TokenEnv": "STP_TEST_KEYS"}
+		]
+	}`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.APIEndpoint != "https://api.example/v1" || cfg.Token != "sk-from-file" {
+		t.Fatalf("unexpected endpoint/token %q %q", cfg.APIEndpoint, cfg.Token)
+	}
+	if cfg.Headers["X-Org"] != "acme" || cfg.Headers["X-Literal"] != "${STP_TEST_ORG}" {
+		t.Fatalf("unexpected headers %v", cfg.Headers)
+	}
+	if string(cfg.ExtraConfig) != `{"user":"acme"}` {
+		t.Fatalf("unexpected ExtraConfig %s", cfg.ExtraConfig)
+	}
+	if cfg.HotKeyConfig[0].Prompt != "keep ${STP_TEST_ORG}" {
+		t.Fatalf("prompt must not be interpolated: %q", cfg.HotKeyConfig[0].Prompt)
+	}
+	if keys := cfg.HotKeyConfig[0].Token.Keys(); len(keys) != 2 || keys[1] != "k2" {
+		t.Fatalf("unexpected TokenFile keys %q", keys)
+	}
+	if keys := cfg.HotKeyConfig[1].Token.Keys(); len(keys) != 2 || keys[0] != "e1" {
+		t.Fatalf("unexpected TokenEnv keys %q", keys)
+	}
+
+	content = `{
+		"Notifiers": [{"Type": "command", "Command": "sh", "Args": ["-c", "notify-send \"${STP_TASK}\""]},
+			{"Type": "webhook", "URL": "https://${STP_TEST_HOST}/hook"}],
+		"FailureNotifications": {"timeout": {"Template": "${STP_ERROR}"}},
+		"HotKeyConfig": [{"Prompt": "a", "FanOut": {"Mode": "all", "Targets": [], "Template": "${STP_TEXT}"}}]
+	}`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = Load(path); err != nil {
+		t.Fatalf("notifier commands and templates must not be interpolated: %v", err)
+	}
+	if args := cfg.Notifiers[0].Args; len(args) != 2 || args[1] != `notify-send "${STP_TASK}"` {
+		t.Fatalf("unexpected notifier Args %q", args)
+	}
+	if cfg.Notifiers[1].URL != "https://api.example/hook" || cfg.HotKeyConfig[0].FanOut.Template != "${STP_TEXT}" {
+		t.Fatalf("unexpected notifier URL or FanOut Template: %q %q", cfg.Notifiers[1].URL, cfg.HotKeyConfig[0].FanOut.Template)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"HotKeyConfig":[{"Prompt":"a","Headers":{"X":"${STP_TEST_UNSET}"}}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "HotKeyConfig[0].Headers.X") {
+		t.Fatalf("expected an error naming the field, got %v", err)
+	}
+}
+
+func TestEnvOverridesBetweenFileAndFlags(t *testing.T) {
+	cfg := Default()
+	cfg.Model = "from-file"
+	cfg.MaxRetry = 7
+	t.Setenv("STP_MODEL", "from-env")
+	t.Setenv("STP_MAX_RETRY", "2")
+	t.Setenv("STP_DEBUG", "true")
+	var stderr bytes.Buffer
+	opts, err := ParseCLI([]string{"-model", "from-flag"}, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyCLI(&cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Model != "from-flag" || cfg.MaxRetry != 2 || !cfg.DEBUG {
+		t.Fatalf("unexpected precedence: model=%s retry=%d debug=%v", cfg.Model, cfg.MaxRetry, cfg.DEBUG)
+	}
+
+	t.Setenv("STP_MAX_RETRY", "many")
+	if err := ApplyCLI(&cfg, CLIOptions{}); err == nil || !strings.Contains(err.Error(), "STP_MAX_RETRY") {
+		t.Fatalf("expected invalid STP_MAX_RETRY to be rejected, got %v", err)
+	}
+}
+
+func TestLoadYAMLAndTOML(t *testing.T) {
+	dir := t.TempDir()
+	yamlDoc := `APIEndpoint: https://api.example/v1
+Temperature: 0.3
+HotKeyConfig:
+  - Name: translate
+    HotKey: ctrl+f1
+    Prompt: |
+      Translate the text.
+      Keep the formatting.
+    ExtraConfig:
+      max_tokens: null
+`
+	tomlDoc := `APIEndpoint = "https://api.example/v1"
+Temperature = 0.3
+
+[[HotKeyConfig]]
+Name = "translate"
+HotKey = "ctrl+f1"
+Prompt = """
+Translate the text.
+Keep the formatting.
+"""
+ExtraConfig = '{"max_tokens":null}'
+`
+	var loaded []Config
+	for name, doc := range map[string]string{"config.yaml": yamlDoc, "config.toml": tomlDoc} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		e := cfg.HotKeyConfig[0]
+		if e.Prompt != "Translate the text.\nKeep the formatting.\n" || string(e.ExtraConfig) != `{"max_tokens":null}` {
+			t.Fatalf("%s: unexpected entry %q %s", name, e.Prompt, e.ExtraConfig)
+		}
+		if cfg.Temperature != 0.3 || cfg.MaxRetry != Default().MaxRetry {
+			t.Fatalf("%s: defaults not kept", name)
+		}
+		loaded = append(loaded, cfg)
+	}
+	if !reflect.DeepEqual(loaded[0], loaded[1]) {
+		t.Fatalf("YAML and TOML configs differ:\n%+v\n%+v", loaded[0], loaded[1])
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	src := `{
+		"Token": ["a", "b"],
+		"MaxRetry": 5,
+		"RetryBaseDelay": 1.5,
+		"OAuth2": null,
+		"Headers": {"X-Org": "${ORG}"},
+		"ExtraConfig": {"max_tokens": null, "verbosity": "low"},
+		"HotKeyConfig": [
+			{"Prompt": "line 1\nline \"2\" \\ end\n", "HotKey": "ctrl+f1", "Examples": [{"User": "u", "Assistant": "a"}]},
+			{"Prompt": "p", "HotKey": "ctrl+f2", "Temperature": 0, "ExtraConfig": "{\"x\":1}"}
+		]
+	}`
+	dir := t.TempDir()
+	t.Setenv("ORG", "acme")
+	load := func(name string, data []byte) Config {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", name, err, data)
+		}
+		return cfg
+	}
+	want := load("src.json", []byte(src))
+	for _, to := range []string{FormatYAML, FormatTOML} {
+		out, err := Convert([]byte(src), FormatJSON, to)
+		if err != nil {
+			t.Fatalf("%s: %v", to, err)
+		}
+		if !strings.Contains(string(out), "${ORG}") {
+			t.Fatalf("%s: references must not be expanded:\n%s", to, out)
+		}
+		if got := load("out."+to, out); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s round trip differs:\n%s", to, out)
+		}
+		back, err := Convert(out, to, FormatJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := load("back-"+to+".json", back); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s -> json differs:\n%s", to, back)
+		}
+	}
+}
+
+func TestLoadPromptsDir(t *testing.T) {
+	dir := t.TempDir()
+	prompts := filepath.Join(dir, "prompts")
+	files := map[string]string{
+		"translate.md":   "---\nhotkey: ctrl+alt+t\nmodel: gpt-4o\nextraconfig:\n  max_tokens: 200\n---\n\n# Translate\n\nTranslate into ${LANG}.\n",
+		"fix/grammar.md": "Fix the grammar.\r\n",
+		".draft.md":      "ignored",
+		"notes.txt":      "ignored",
+		"summary.md":     "---\nName: summarize\nHotKey: ctrl+alt+s\nToken: ${STP_TEST_PROMPT_TOKEN}\n---\nSummarize.\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(prompts, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("STP_TEST_PROMPT_TOKEN", "sk-prompt")
+	path := filepath.Join(dir, "config.json")
+	content := `{"PromptsDir": "prompts", "HotKeyConfig": [
+		{"Name": "translate", "Prompt": "old", "HotKey": "ctrl+f1", "Temperature": 0.2},
+		{"Prompt": "other", "HotKey": "ctrl+f2"}
+	]}`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PromptsDir != prompts {
+		t.Fatalf("PromptsDir = %q, want %q", cfg.PromptsDir, prompts)
+	}
+	var got []string
+	for _, e := range cfg.HotKeyConfig {
+		got = append(got, e.Name+"|"+e.HotKey+"|"+e.Prompt)
+	}
+	want := []string{
+		"translate|ctrl+alt+t|# Translate\n\nTranslate into ${LANG}.",
+		"|ctrl+f2|other",
+		"fix/grammar||Fix the grammar.",
+		"summarize|ctrl+alt+s|Summarize.",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("entries = %q, want %q", got, want)
+	}
+	tr := cfg.HotKeyConfig[0]
+	if tr.Model != "gpt-4o" || tr.Temperature == nil || *tr.Temperature != 0.2 || string(tr.ExtraConfig) != `{"max_tokens":200}` {
+		t.Fatalf("front matter not merged into the named entry: %+v", tr)
+	}
+	if cfg.HotKeyConfig[3].Token != "sk-prompt" {
+		t.Fatalf("front matter not interpolated: %q", cfg.HotKeyConfig[3].Token)
+	}
+
+	if err := os.WriteFile(filepath.Join(prompts, "bad.md"), []byte("---\nhotkeys: x\n---\nbody"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), `unknown front matter field "hotkeys"`) {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+}
+
+func TestLoadLayeredTLSPaths(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("tls/base.json", `{"Include": "certs/client.yaml"}`)
+	other := filepath.Join(dir, "other.pem")
+	quoted, _ := json.Marshal(other)
+	write("tls/certs/client.yaml", "CAFiles: [ca.pem, "+string(quoted)+"]\nClientCertFile: client.pem\n")
+	path := write("config.json", `{"Include": "tls/base.json", "ClientKeyFile": "keys/client.key"}`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := filepath.Join(dir, "tls", "certs")
+	if want := []string{filepath.Join(certs, "ca.pem"), other}; !reflect.DeepEqual(cfg.CAFiles, want) {
+		t.Fatalf("CAFiles = %q, want %q", cfg.CAFiles, want)
+	}
+	if want := filepath.Join(certs, "client.pem"); cfg.ClientCertFile != want {
+		t.Fatalf("ClientCertFile = %q, want %q", cfg.ClientCertFile, want)
+	}
+	if want := filepath.Join(dir, "keys", "client.key"); cfg.ClientKeyFile != want {
+		t.Fatalf("ClientKeyFile = %q, want %q", cfg.ClientKeyFile, want)
+	}
+}
+
+func TestLoadSecretPathsIgnoresOtherSettings(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "team"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "team", "base.json"), []byte(`{"SecretsFile": "team-secrets.json", "Token": "${STP_TEST_UNSET_VAR}"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"Extends": "team/base.json", "SecretKeyFile": "local.key", "TokenEnv": "STP_TEST_UNSET_VAR"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected the full load to fail")
+	}
+	secretsFile, keyFile, err := LoadSecretPaths(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "team", "team-secrets.json"); secretsFile != want {
+		t.Fatalf("SecretsFile = %q, want %q", secretsFile, want)
+	}
+	if want := filepath.Join(dir, "local.key"); keyFile != want {
+		t.Fatalf("SecretKeyFile = %q, want %q", keyFile, want)
+	}
+}
+
+func TestLoadLayeredConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	base := write("team/base.yaml", `APIEndpoint: https://team/v1
+Model: base-model
+Proxy: http://proxy:8080
+RetryableStatusCodes: [429, 503]
+SecretsFile: team-secrets.json
+Headers:
+  X-Team: core
+  X-Env: prod
+ExtraConfig:
+  top_p: 0.9
+  seed: 1
+HotKeyConfig:
+  - Name: translate
+    HotKey: ctrl+alt+t
+    Prompt: Translate.
+    Headers: {X-Task: translate}
+  - Name: summarize
+    HotKey: ctrl+alt+s
+    Prompt: Summarize.
+`)
+	write("local.json", `{"Headers": {"X-Env": "dev"}}`)
+	path := write("config.json", `{
+		"Extends": "team/base.yaml",
+		"Include": ["local.json"],
+		"Token": "sk-me",
+		"Proxy": null,
+		"RetryableStatusCodes": [500],
+		"ExtraConfig": {"seed": 2},
+		"HotKeyConfig": [
+			{"Name": "TRANSLATE", "Model": "mine", "Headers": {"X-User": "me"}},
+			{"Name": "personal", "HotKey": "ctrl+alt+p", "Prompt": "Mine."}
+		]
+	}`)
+
+	cfg, origins, err := LoadWithOrigins(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.APIEndpoint != "https://team/v1" || cfg.Model != "base-model" || cfg.Token != "sk-me" {
+		t.Fatalf("scalars not layered: %q %q %q", cfg.APIEndpoint, cfg.Model, cfg.Token)
+	}
+	if cfg.Proxy != Default().Proxy {
+		t.Fatalf("null should restore the default Proxy, got %q", cfg.Proxy)
+	}
+	if !reflect.DeepEqual(cfg.RetryableStatusCodes, []int{500}) || string(cfg.ExtraConfig) != `{"seed":2}` {
+		t.Fatalf("arrays and ExtraConfig should be replaced: %v %s", cfg.RetryableStatusCodes, cfg.ExtraConfig)
+	}
+	if want := map[string]string{"X-Team": "core", "X-Env": "dev"}; !reflect.DeepEqual(cfg.Headers, want) {
+		t.Fatalf("Headers = %v, want %v", cfg.Headers, want)
+	}
+	if want := filepath.Join(dir, "team", "team-secrets.json"); cfg.SecretsFile != want {
+		t.Fatalf("SecretsFile = %q, want %q relative to its file", cfg.SecretsFile, want)
+	}
+	if len(cfg.HotKeyConfig) != 3 {
+		t.Fatalf("expected 3 entries, got %+v", cfg.HotKeyConfig)
+	}
+	tr := cfg.HotKeyConfig[0]
+	if tr.Prompt != "Translate." || tr.Model != "mine" || !reflect.DeepEqual(tr.Headers, map[string]string{"X-Task": "translate", "X-User": "me"}) {
+		t.Fatalf("entry not merged by name: %+v", tr)
+	}
+	if cfg.HotKeyConfig[2].Name != "personal" {
+		t.Fatalf("new entry not appended: %+v", cfg.HotKeyConfig[2])
+	}
+
+	localPath := filepath.Join(dir, "local.json")
+	for setting, want := range map[string]string{
+		"APIEndpoint":             base,
+		"Token":                   path,
+		"Proxy":                   DefaultOrigin,
+		"Headers.X-Team":          base,
+		"Headers.X-Env":           localPath,
+		"ExtraConfig":             path,
+		"HotKeyConfig[0].Prompt":  base,
+		"HotKeyConfig[0].Model":   path,
+		"HotKeyConfig[2].HotKey":  path,
+		"HotKeyConfig[1].Headers": DefaultOrigin,
+	} {
+		if got := origins.Of(setting); got != want {
+			t.Errorf("origin of %s = %q, want %q", setting, got, want)
+		}
+	}
+	settings, err := Settings(cfg, origins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, s := range settings {
+		if s.Path == "HotKeyConfig[0].Model" {
+			found = s.Value == `"mine"` && s.Origin == path
+		}
+	}
+	if !found {
+		t.Fatalf("Settings misses HotKeyConfig[0].Model: %+v", settings)
+	}
+
+	write("local.json", `{"Include": "config.json"}`)
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "include each other") {
+		t.Fatalf("expected an include cycle error, got %v", err)
+	}
+}
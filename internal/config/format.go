@@ -0,0 +1,287 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config file formats.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// FormatOf returns the format of a config file from its extension; files
+// other than .yaml, .yml and .toml are JSON.
+func FormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatJSON
+}
+
+// DefaultFiles are the config files looked for in the working directory
+// when no -config is given, in order.
+var DefaultFiles = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// FindDefault returns the first of DefaultFiles that exists, or "".
+func FindDefault() string {
+	for _, name := range DefaultFiles {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// decodeDocument parses a config document into the value types of
+// encoding/json (maps, []interface{}, strings, bools and nil) with int64
+// and float64 numbers, whatever the format.
+func decodeDocument(data []byte, format string) (interface{}, error) {
+	var doc interface{}
+	switch format {
+	case FormatJSON:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			return nil, err
+		}
+		if _, err := dec.Token(); err != io.EOF {
+			return nil, fmt.Errorf("unexpected data after the config document")
+		}
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	case FormatTOML:
+		var m map[string]interface{}
+		if _, err := toml.Decode(string(data), &m); err != nil {
+			return nil, err
+		}
+		doc = m
+	default:
+		return nil, fmt.Errorf("unknown config format %q", format)
+	}
+	return normalize(doc)
+}
+
+// normalize converts the value types of the YAML and TOML decoders to
+// those of encoding/json.
+func normalize(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			n, err := normalize(child)
+			if err != nil {
+				return nil, err
+			}
+			t[k] = n
+		}
+		return t, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, child := range t {
+			n, err := normalize(child)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = n
+		}
+		return m, nil
+	case []map[string]interface{}:
+		out := make([]interface{}, len(t))
+		for i, child := range t {
+			n, err := normalize(child)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = n
+		}
+		return out, nil
+	case []interface{}:
+		for i, child := range t {
+			n, err := normalize(child)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = n
+		}
+		return t, nil
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		return t.Float64()
+	case int:
+		return int64(t), nil
+	case uint64:
+		return float64(t), nil
+	case time.Time:
+		return t.Format(time.RFC3339Nano), nil
+	}
+	return v, nil
+}
+
+// encodeDocument writes doc, as returned by decodeDocument, in format.
+func encodeDocument(doc interface{}, format string) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case FormatYAML:
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case FormatTOML:
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("a TOML document must be a table")
+		}
+		t, err := tomlValue(m, "", "")
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		enc := toml.NewEncoder(&buf)
+		enc.Indent = ""
+		if err := enc.Encode(t); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown config format %q", format)
+}
+
+// tomlValue prepares v for the TOML encoder. TOML has no null: a null
+// inside ExtraConfig or ExtraPatch is kept by writing that value in its
+// JSON string form, other nulls mean "not set" and are dropped. Multi-line
+// strings are written as multi-line TOML strings.
+func tomlValue(v interface{}, key, path string) (interface{}, error) {
+	if (key == "ExtraConfig" || key == "ExtraPatch") && containsNull(v) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if t[k] == nil {
+				continue
+			}
+			child := k
+			if path != "" {
+				child = path + "." + k
+			}
+			n, err := tomlValue(t[k], k, child)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = n
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, child := range t {
+			if child == nil {
+				return nil, fmt.Errorf("%s[%d]: TOML arrays cannot hold null", path, i)
+			}
+			n, err := tomlValue(child, "", fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = n
+		}
+		return out, nil
+	case string:
+		if strings.Contains(t, "\n") {
+			return tomlMultiline(t), nil
+		}
+	}
+	return v, nil
+}
+
+func containsNull(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		for _, child := range t {
+			if containsNull(child) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, child := range t {
+			if containsNull(child) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tomlMultiline is a string written as a TOML multi-line basic string so
+// that prompts stay readable.
+type tomlMultiline string
+
+var tomlMultilineReplacer = strings.NewReplacer(`\`, `\\`, `"""`, `""\"`, "\r", `\r`, "\b", `\b`, "\f", `\f`)
+
+func (s tomlMultiline) MarshalTOML() ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("\"\"\"\n")
+	for _, r := range tomlMultilineReplacer.Replace(string(s)) {
+		if (r < 0x20 && r != '\n' && r != '\t') || r == 0x7f {
+			fmt.Fprintf(&b, `\u%04x`, r)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	b.WriteString(`"""`)
+	return []byte(b.String()), nil
+}
+
+// Convert rewrites a config document from one format into another without
+// applying defaults, interpolation or secrets, so that the result loads
+// into the same configuration.
+func Convert(data []byte, from, to string) ([]byte, error) {
+	doc, err := decodeDocument(data, from)
+	if err != nil {
+		return nil, err
+	}
+	return encodeDocument(doc, to)
+}
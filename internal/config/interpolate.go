@@ -0,0 +1,169 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// refPattern matches "$${" (an escaped "${") and ${...} references.
+var refPattern = regexp.MustCompile(`\$\$\{|\$\{([^{}]*)\}`)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// noInterpolation lists the keys that are never interpolated: prompts and
+// examples are sent to the model verbatim, and templates and notifier
+// commands expand their own variables (such as ${STP_TASK}) at run time.
+var noInterpolation = map[string]bool{
+	"Prompt":               true,
+	"Examples":             true,
+	"Template":             true,
+	"FailureNotifications": true,
+	"Command":              true,
+	"Args":                 true,
+}
+
+// Interpolate replaces the references in s:
+//
+//	${VAR} or ${env:VAR}  the value of environment variable VAR
+//	${file:path}          the content of the file without trailing newlines;
+//	                      a relative path is resolved against dir
+//	$${                   a literal "${"
+//
+// An unset variable or unreadable file is an error so that a missing
+// secret is noticed at startup rather than sent as an empty token.
+func Interpolate(s, dir string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var firstErr error
+	out := refPattern.ReplaceAllStringFunc(s, func(m string) string {
+		if m == "$${" {
+			return "${"
+		}
+		v, err := resolveRef(m[2:len(m)-1], dir)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		return v
+	})
+	return out, firstErr
+}
+
+func resolveRef(ref, dir string) (string, error) {
+	ref = strings.TrimSpace(ref)
+	if path, ok := strings.CutPrefix(ref, "file:"); ok {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			return "", fmt.Errorf("empty file reference ${%s}", ref)
+		}
+		b, err := os.ReadFile(relativeTo(dir, path))
+		if err != nil {
+			return "", fmt.Errorf("read ${file:...}: %w", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	name := strings.TrimPrefix(ref, "env:")
+	if !envNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid reference ${%s}", ref)
+	}
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return v, nil
+}
+
+// interpolateTree interpolates every string of a decoded config document in
+// place. path names the current value in error messages.
+func interpolateTree(v interface{}, path, dir string) (interface{}, error) {
+	switch t := v.(type) {
+	case string:
+		s, err := Interpolate(t, dir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return s, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if noInterpolation[k] {
+				continue
+			}
+			child := k
+			if path != "" {
+				child = path + "." + k
+			}
+			out, err := interpolateTree(t[k], child, dir)
+			if err != nil {
+				return nil, err
+			}
+			t[k] = out
+		}
+	case []interface{}:
+		for i := range t {
+			out, err := interpolateTree(t[i], fmt.Sprintf("%s[%d]", path, i), dir)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = out
+		}
+	}
+	return v, nil
+}
+
+// resolveTokenRef fills an empty token from the environment variable env
+// (comma separated keys) or from file (one key per line, '#' starts a
+// comment). A Token set directly wins over both.
+func resolveTokenRef(t *TokenList, env, file, dir string) error {
+	if t.First() != "" {
+		return nil
+	}
+	if env = strings.TrimSpace(env); env != "" {
+		v, ok := os.LookupEnv(env)
+		if !ok || strings.TrimSpace(v) == "" {
+			return fmt.Errorf("TokenEnv: environment variable %s is not set", env)
+		}
+		*t = NewTokenList(SplitList(v)...)
+		return nil
+	}
+	if file = strings.TrimSpace(file); file != "" {
+		file = relativeTo(dir, file)
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("TokenFile: %w", err)
+		}
+		var keys []string
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				keys = append(keys, line)
+			}
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("TokenFile: %s contains no token", file)
+		}
+		*t = NewTokenList(keys...)
+	}
+	return nil
+}
+
+// resolveTokenRefs applies TokenEnv and TokenFile of the global config and
+// of every entry.
+func (c *Config) resolveTokenRefs(dir string) error {
+	if err := resolveTokenRef(&c.Token, c.TokenEnv, c.TokenFile, dir); err != nil {
+		return err
+	}
+	for i := range c.HotKeyConfig {
+		e := &c.HotKeyConfig[i]
+		if err := resolveTokenRef(&e.Token, e.TokenEnv, e.TokenFile, dir); err != nil {
+			return fmt.Errorf("HotKeyConfig[%d].%w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,406 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Keys naming the files layered with a config file.
+const (
+	// ExtendsKey lists files applied before the file, which overrides them.
+	ExtendsKey = "Extends"
+	// IncludeKey lists files applied after the file, in order, which
+	// override it.
+	IncludeKey = "Include"
+)
+
+// DefaultOrigin is the origin of settings no config file sets.
+const DefaultOrigin = "default"
+
+// opaqueKeys are replaced as a whole by a later layer instead of being
+// merged key by key, since their content is sent to the API as is.
+var opaqueKeys = map[string]bool{"ExtraConfig": true, "ExtraPatch": true}
+
+// layerPathKeys are the file path settings, strings or arrays of strings,
+// that are relative to the file they appear in. Paths of the main config
+// file are resolved by Load, those of other layers are made absolute when
+// the layer is read.
+var layerPathKeys = []string{"SecretsFile", "SecretKeyFile", "PromptsDir", "TokenFile", "ClientCertFile", "ClientKeyFile", "CAFiles"}
+
+// Origins maps the path of a setting, e.g. "Model", "Headers.X-Org" or
+// "HotKeyConfig[2].Prompt", to the file that set it.
+type Origins map[string]string
+
+// Of returns the file that set path or one of its parents, or
+// DefaultOrigin.
+func (o Origins) Of(path string) string {
+	for p := path; p != ""; p = parentPath(p) {
+		if file, ok := o[p]; ok {
+			return file
+		}
+	}
+	return DefaultOrigin
+}
+
+func parentPath(p string) string {
+	if i := strings.LastIndexAny(p, ".["); i > 0 {
+		return p[:i]
+	}
+	return ""
+}
+
+// set records file as the origin of v at path: every leaf of a map, and
+// arrays, opaque values and scalars as a whole.
+func (o Origins) set(path string, v interface{}, file string) {
+	if o == nil {
+		return
+	}
+	o.clear(path)
+	m, ok := v.(map[string]interface{})
+	if !ok || opaqueKeys[lastKey(path)] {
+		o[path] = file
+		return
+	}
+	for k, child := range m {
+		o.set(joinPath(path, k), child, file)
+	}
+}
+
+// clear drops the origins of path and everything below it.
+func (o Origins) clear(path string) {
+	for p := range o {
+		if p == path || strings.HasPrefix(p, path+".") || strings.HasPrefix(p, path+"[") {
+			delete(o, p)
+		}
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func lastKey(path string) string {
+	return path[strings.LastIndexAny(path, ".]")+1:]
+}
+
+// layer is one config file of a layered configuration.
+type layer struct {
+	path string
+	doc  map[string]interface{}
+}
+
+// readLayers returns the layers of the config file at path in the order
+// they apply: its Extends (recursively), the file itself, then its Include
+// (recursively). stack holds the files being read, to detect cycles. When
+// keep is not nil, settings other than those in keep are dropped before the
+// layer is interpolated, so that they cannot fail the read.
+func readLayers(path string, main bool, stack []string, keep map[string]bool) ([]layer, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range stack {
+		if p == abs {
+			return nil, fmt.Errorf("config files include each other: %s -> %s", strings.Join(stack, " -> "), abs)
+		}
+	}
+	stack = append(stack, abs)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	v, err := decodeDocument(data, FormatOf(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if v == nil {
+		v = map[string]interface{}{}
+	}
+	doc, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: the config document must be an object", path)
+	}
+	if keep != nil {
+		for k := range doc {
+			if !keep[k] && k != ExtendsKey && k != IncludeKey {
+				delete(doc, k)
+			}
+		}
+	}
+	dir := filepath.Dir(path)
+	if _, err := interpolateTree(doc, "", dir); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	extends, err := takeFileList(doc, ExtendsKey, dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	includes, err := takeFileList(doc, IncludeKey, dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if !main {
+		if err := absLayerPaths(doc, dir); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	var layers []layer
+	for _, p := range extends {
+		l, err := readLayers(p, false, stack, keep)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, l...)
+	}
+	layers = append(layers, layer{path: path, doc: doc})
+	for _, p := range includes {
+		l, err := readLayers(p, false, stack, keep)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, l...)
+	}
+	return layers, nil
+}
+
+// takeFileList removes key from doc and returns its file names, a string
+// or an array of strings, relative to dir.
+func takeFileList(doc map[string]interface{}, key, dir string) ([]string, error) {
+	v, ok := doc[key]
+	if !ok {
+		return nil, nil
+	}
+	delete(doc, key)
+	var names []string
+	switch t := v.(type) {
+	case nil:
+	case string:
+		names = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a file name or an array of file names", key)
+			}
+			names = append(names, s)
+		}
+	default:
+		return nil, fmt.Errorf("%s must be a file name or an array of file names", key)
+	}
+	var files []string
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			files = append(files, relativeTo(dir, name))
+		}
+	}
+	return files, nil
+}
+
+// absLayerPaths makes the layerPathKeys of doc and of its HotKeyConfig
+// entries absolute against dir.
+func absLayerPaths(doc map[string]interface{}, dir string) error {
+	maps := []map[string]interface{}{doc}
+	if entries, ok := doc["HotKeyConfig"].([]interface{}); ok {
+		for _, e := range entries {
+			if m, ok := e.(map[string]interface{}); ok {
+				maps = append(maps, m)
+			}
+		}
+	}
+	for _, m := range maps {
+		for _, key := range layerPathKeys {
+			switch v := m[key].(type) {
+			case string:
+				abs, err := absPath(dir, v)
+				if err != nil {
+					return err
+				}
+				m[key] = abs
+			case []interface{}:
+				for i, item := range v {
+					if s, ok := item.(string); ok {
+						abs, err := absPath(dir, s)
+						if err != nil {
+							return err
+						}
+						v[i] = abs
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// absPath makes a non-empty path absolute against dir.
+func absPath(dir, path string) (string, error) {
+	if strings.TrimSpace(path) == "" {
+		return path, nil
+	}
+	return filepath.Abs(relativeTo(dir, strings.TrimSpace(path)))
+}
+
+// mergeLayers merges the layers in order and records the origin of every
+// setting:
+//
+//   - objects are merged key by key, a later value replacing an earlier one;
+//   - null removes the setting, so that the default applies again;
+//   - arrays, ExtraConfig and ExtraPatch are replaced as a whole;
+//   - a HotKeyConfig entry with the Name of an earlier entry (compared
+//     case-insensitively) is merged into it by these rules, other entries
+//     are appended.
+func mergeLayers(layers []layer, origins Origins) (map[string]interface{}, error) {
+	merged := map[string]interface{}{}
+	for _, l := range layers {
+		if err := mergeObject(merged, l.doc, "", l.path, origins); err != nil {
+			return nil, fmt.Errorf("%s: %w", l.path, err)
+		}
+	}
+	return merged, nil
+}
+
+func mergeObject(dst, src map[string]interface{}, path, file string, origins Origins) error {
+	keys := make([]string, 0, len(src))
+	for k := range src {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, p := src[k], joinPath(path, k)
+		if v == nil {
+			delete(dst, k)
+			origins.clear(p)
+			continue
+		}
+		if path == "" && k == "HotKeyConfig" {
+			if err := mergeEntries(dst, v, file, origins); err != nil {
+				return err
+			}
+			continue
+		}
+		if srcMap, ok := v.(map[string]interface{}); ok && !opaqueKeys[k] {
+			if dstMap, ok := dst[k].(map[string]interface{}); ok {
+				if err := mergeObject(dstMap, srcMap, p, file, origins); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		dst[k] = v
+		origins.set(p, v, file)
+	}
+	return nil
+}
+
+// mergeEntries merges the HotKeyConfig array v into dst by entry Name.
+func mergeEntries(dst map[string]interface{}, v interface{}, file string, origins Origins) error {
+	src, ok := v.([]interface{})
+	if !ok {
+		return fmt.Errorf("HotKeyConfig must be an array")
+	}
+	entries, _ := dst["HotKeyConfig"].([]interface{})
+	for _, item := range src {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("HotKeyConfig entries must be objects")
+		}
+		idx := -1
+		if name := entryName(entry); name != "" {
+			for i, e := range entries {
+				if strings.EqualFold(entryName(e.(map[string]interface{})), name) {
+					idx = i
+					break
+				}
+			}
+		}
+		if idx < 0 {
+			idx = len(entries)
+			entries = append(entries, map[string]interface{}{})
+		}
+		if err := mergeObject(entries[idx].(map[string]interface{}), entry, fmt.Sprintf("HotKeyConfig[%d]", idx), file, origins); err != nil {
+			return err
+		}
+	}
+	dst["HotKeyConfig"] = entries
+	return nil
+}
+
+func entryName(entry map[string]interface{}) string {
+	s, _ := entry["Name"].(string)
+	return strings.TrimSpace(s)
+}
+
+// Setting is one effective setting: its path, its value as compact JSON
+// and the file it comes from.
+type Setting struct {
+	Path   string
+	Value  string
+	Origin string
+}
+
+// Settings lists the effective settings of c in field order, with the
+// origins recorded by LoadWithOrigins. Objects are listed key by key, the
+// HotKeyConfig array entry by entry; other arrays, ExtraConfig and
+// ExtraPatch are single settings.
+func Settings(c Config, origins Origins) ([]Setting, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var out []Setting
+	if err := flattenSettings(data, "", &out); err != nil {
+		return nil, err
+	}
+	for i := range out {
+		out[i].Origin = origins.Of(out[i].Path)
+	}
+	return out, nil
+}
+
+func flattenSettings(raw json.RawMessage, path string, out *[]Setting) error {
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case len(raw) > 2 && raw[0] == '{' && !opaqueKeys[lastKey(path)]:
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			var child json.RawMessage
+			if err := dec.Decode(&child); err != nil {
+				return err
+			}
+			if err := flattenSettings(child, joinPath(path, t.(string)), out); err != nil {
+				return err
+			}
+		}
+		return nil
+	case path == "HotKeyConfig" && len(raw) > 0 && raw[0] == '[':
+		var entries []json.RawMessage
+		if err := json.Unmarshal(raw, &entries); err != nil {
+			return err
+		}
+		for i, e := range entries {
+			if err := flattenSettings(e, fmt.Sprintf("%s[%d]", path, i), out); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	*out = append(*out, Setting{Path: path, Value: string(raw)})
+	return nil
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// PromptFileExt is the extension of the prompt files in PromptsDir.
+const PromptFileExt = ".md"
+
+// entryFields maps the lower-cased JSON names of the HotKeyEntry fields to
+// their canonical spelling, so that front matter may write "hotkey" or
+// "model" as well as "HotKey" or "Model".
+var entryFields = func() map[string]string {
+	m := map[string]string{}
+	t := reflect.TypeOf(HotKeyEntry{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			m[strings.ToLower(name)] = name
+		}
+	}
+	return m
+}()
+
+// PromptFile is one parsed prompt file: the front matter, with its keys in
+// canonical HotKeyEntry spelling, and the Markdown body.
+type PromptFile struct {
+	Path  string
+	Name  string
+	Front map[string]interface{}
+	Body  string
+}
+
+// ParsePromptFile splits a prompt file into its YAML front matter, delimited
+// by "---" lines at the top of the file, and its body. A file without front
+// matter is all body. name is the default entry Name.
+func ParsePromptFile(path, name string, data []byte) (PromptFile, error) {
+	pf := PromptFile{Path: path, Name: name, Front: map[string]interface{}{}}
+	text := strings.TrimPrefix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\ufeff")
+	if rest, ok := strings.CutPrefix(text, "---\n"); ok {
+		end := -1
+		for off := 0; off <= len(rest); {
+			line, _, _ := strings.Cut(rest[off:], "\n")
+			if t := strings.TrimRight(line, " \t"); t == "---" || t == "..." {
+				end = off
+				break
+			}
+			off += len(line) + 1
+		}
+		if end < 0 {
+			return pf, fmt.Errorf("%s: front matter is not closed with ---", path)
+		}
+		doc, err := decodeDocument([]byte(rest[:end]), FormatYAML)
+		if err != nil {
+			return pf, fmt.Errorf("%s: front matter: %w", path, err)
+		}
+		switch m := doc.(type) {
+		case nil:
+		case map[string]interface{}:
+			for k, v := range m {
+				canonical, ok := entryFields[strings.ToLower(k)]
+				if !ok {
+					return pf, fmt.Errorf("%s: unknown front matter field %q", path, k)
+				}
+				pf.Front[canonical] = v
+			}
+		default:
+			return pf, fmt.Errorf("%s: front matter must be a mapping", path)
+		}
+		_, text, _ = strings.Cut(rest[end:], "\n")
+	}
+	pf.Body = strings.TrimSpace(text)
+	if v, ok := pf.Front["Name"].(string); ok && strings.TrimSpace(v) != "" {
+		pf.Name = strings.TrimSpace(v)
+	}
+	pf.Front["Name"] = pf.Name
+	if pf.Body != "" {
+		pf.Front["Prompt"] = pf.Body
+	}
+	return pf, nil
+}
+
+// ReadPromptsDir parses the prompt files under dir in lexical order. The
+// default Name of a file is its path relative to dir without the extension,
+// e.g. "translate/en" for translate/en.md. Names must be unique.
+func ReadPromptsDir(dir string) ([]PromptFile, error) {
+	var files []PromptFile
+	seen := map[string]string{}
+	err := walkPrompts(dir, func(path string, d fs.DirEntry) error {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel)))
+		pf, err := ParsePromptFile(path, name, data)
+		if err != nil {
+			return err
+		}
+		if other, ok := seen[strings.ToLower(pf.Name)]; ok {
+			return fmt.Errorf("%s: prompt name %q is also used by %s", path, pf.Name, other)
+		}
+		seen[strings.ToLower(pf.Name)] = path
+		files = append(files, pf)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("PromptsDir: %w", err)
+	}
+	return files, nil
+}
+
+// walkPrompts calls fn for each prompt file under dir in lexical order,
+// skipping files and directories whose names start with ".".
+func walkPrompts(dir string, fn func(path string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !strings.EqualFold(filepath.Ext(path), PromptFileExt) {
+			return nil
+		}
+		return fn(path, d)
+	})
+}
+
+// PromptsState returns a fingerprint of the prompt files under dir that
+// changes whenever a file is added, removed or modified.
+func PromptsState(dir string) (string, error) {
+	var b strings.Builder
+	err := walkPrompts(dir, func(path string, d fs.DirEntry) error {
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s|%d|%d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return b.String(), err
+}
+
+// loadPrompts merges the prompt files of PromptsDir into HotKeyConfig. A
+// file whose Name matches an entry (case-insensitively) replaces the fields
+// it sets in that entry; other files are appended as new entries. String
+// values in front matter are interpolated like the rest of the config, the
+// prompt body is not. The fields a file sets are recorded in origins.
+func (c *Config) loadPrompts(dir string, origins Origins) error {
+	if strings.TrimSpace(c.PromptsDir) == "" {
+		return nil
+	}
+	c.PromptsDir = relativeTo(dir, strings.TrimSpace(c.PromptsDir))
+	files, err := ReadPromptsDir(c.PromptsDir)
+	if err != nil {
+		return err
+	}
+	for _, pf := range files {
+		front, err := interpolateTree(pf.Front, pf.Path, dir)
+		if err != nil {
+			return err
+		}
+		idx := -1
+		for i, e := range c.HotKeyConfig {
+			if strings.TrimSpace(e.Name) != "" && strings.EqualFold(strings.TrimSpace(e.Name), pf.Name) {
+				idx = i
+				break
+			}
+		}
+		merged := map[string]interface{}{}
+		if idx >= 0 {
+			b, err := json.Marshal(c.HotKeyConfig[idx])
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(b, &merged); err != nil {
+				return err
+			}
+		}
+		for k, v := range front.(map[string]interface{}) {
+			merged[k] = v
+		}
+		b, err := json.Marshal(merged)
+		if err != nil {
+			return err
+		}
+		var entry HotKeyEntry
+		if err := json.Unmarshal(b, &entry); err != nil {
+			return fmt.Errorf("%s: %w", pf.Path, err)
+		}
+		if idx < 0 {
+			idx = len(c.HotKeyConfig)
+			c.HotKeyConfig = append(c.HotKeyConfig, entry)
+		} else {
+			c.HotKeyConfig[idx] = entry
+		}
+		for k, v := range front.(map[string]interface{}) {
+			origins.set(fmt.Sprintf("HotKeyConfig[%d].%s", idx, k), v, pf.Path)
+		}
+	}
+	return nil
+}
@@ -20,6 +20,7 @@ const (
 	wmSysKeyDown  = 0x0104
 	wmSysKeyUp    = 0x0105
 	wmHotkey      = 0x0312
+	wmQuit        = 0x0012
 
 	vkControl = 0x11
 	vkMenu    = 0x12
@@ -43,6 +44,11 @@ type platformService struct {
 	handler func(Event)
 	stopCh  chan struct{}
 
+	// threadID is the thread running the message loop; done is closed
+	// once the loop has exited and released its hotkeys or hook.
+	threadID uint32
+	done     chan struct{}
+
 	// register hotkey mode
 	registered []int
 
@@ -63,6 +69,7 @@ func newPlatformService(opts Options) Service {
 		opts:      opts,
 		events:    make(chan Event, 32),
 		stopCh:    make(chan struct{}),
+		done:      make(chan struct{}),
 		llBlocked: make(map[uint32]bool),
 	}
 }
@@ -76,9 +83,28 @@ func (s *platformService) Start(handler func(Event)) error {
 	return s.startRegisterHotkey()
 }
 
+// Close stops the dispatcher and ends the message loop, which unregisters
+// the hotkeys (or removes the hook) so that a new service can take them.
 func (s *platformService) Close() error {
 	close(s.stopCh)
-	return nil
+	if s.threadID == 0 {
+		return nil
+	}
+	postMsg := syscall.NewLazyDLL("user32.dll").NewProc("PostThreadMessageW")
+	if r, _, e := postMsg.Call(uintptr(s.threadID), wmQuit, 0, 0); r == 0 {
+		return fmt.Errorf("PostThreadMessageW failed: %v", e)
+	}
+	select {
+	case <-s.done:
+		return nil
+	case <-time.After(2 * time.Second):
+		return fmt.Errorf("timeout stopping hotkey message loop")
+	}
+}
+
+func currentThreadID() uint32 {
+	r, _, _ := syscall.NewLazyDLL("kernel32.dll").NewProc("GetCurrentThreadId").Call()
+	return uint32(r)
 }
 
 func (s *platformService) runDispatcher() {
@@ -137,6 +163,7 @@ func (s *platformService) startRegisterHotkey() error {
 	go func() {
 		runtime.LockOSThread()
 		defer runtime.UnlockOSThread()
+		defer close(s.done)
 
 		user32 := syscall.NewLazyDLL("user32.dll")
 		reg := user32.NewProc("RegisterHotKey")
@@ -154,6 +181,7 @@ func (s *platformService) startRegisterHotkey() error {
 			}
 			s.registered = append(s.registered, e.id)
 		}
+		s.threadID = currentThreadID()
 		errCh <- nil
 
 		var msg struct {
@@ -166,14 +194,20 @@ func (s *platformService) startRegisterHotkey() error {
 			PtY     int32
 		}
 		for {
+			// GetMessageW returns 0 for WM_QUIT (posted by Close) and -1
+			// on error.
 			ret, _, _ := getMsg.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0)
-			if int32(ret) == -1 {
-				return
+			if int32(ret) <= 0 {
+				break
 			}
 			if msg.Message == wmHotkey {
 				s.emitByID(int(msg.WParam))
 			}
 		}
+		for _, id := range s.registered {
+			unreg.Call(0, uintptr(id))
+		}
+		s.registered = nil
 	}()
 
 	select {
@@ -201,6 +235,7 @@ func (s *platformService) startLowLevelHook() error {
 	go func() {
 		runtime.LockOSThread()
 		defer runtime.UnlockOSThread()
+		defer close(s.done)
 
 		user32 := syscall.NewLazyDLL("user32.dll")
 		setHook := user32.NewProc("SetWindowsHookExW")
@@ -217,6 +252,7 @@ func (s *platformService) startLowLevelHook() error {
 			return
 		}
 		s.llHookHandle = h
+		s.threadID = currentThreadID()
 		errCh <- nil
 
 		var msg struct {
@@ -230,11 +266,14 @@ func (s *platformService) startLowLevelHook() error {
 		}
 		for {
 			ret, _, _ := getMsg.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0)
-			if int32(ret) == -1 {
+			if int32(ret) <= 0 {
 				break
 			}
 		}
 		unhook.Call(s.llHookHandle)
+		if activeLLService == s {
+			activeLLService = nil
+		}
 	}()
 
 	select {
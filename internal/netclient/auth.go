@@ -0,0 +1,113 @@
+package netclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Auth schemes for sending the token.
+const (
+	AuthBearer = "bearer" // Authorization: Bearer <token>
+	AuthHeader = "header" // <AuthParam>: <token>
+	AuthQuery  = "query"  // ?<AuthParam>=<token>
+	AuthBasic  = "basic"  // Authorization: Basic, token is "user:password"
+	AuthNone   = "none"   // token is not sent
+)
+
+const (
+	defaultAuthHeader = "api-key"
+	defaultAuthQuery  = "key"
+)
+
+// ValidateAuth reports whether scheme is known. An empty scheme means bearer.
+func ValidateAuth(scheme, param string) error {
+	switch normalizeAuthScheme(scheme) {
+	case AuthBearer, AuthQuery, AuthBasic, AuthNone, AuthSigV4:
+		return nil
+	case AuthHeader:
+		if p := strings.TrimSpace(param); p != "" && !validHeaderName(p) {
+			return fmt.Errorf("invalid auth header name %q", p)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown auth scheme %q (want bearer, header, query, basic, sigv4 or none)", scheme)
+	}
+}
+
+func normalizeAuthScheme(scheme string) string {
+	s := strings.ToLower(strings.TrimSpace(scheme))
+	if s == "" {
+		return AuthBearer
+	}
+	return s
+}
+
+// setAuth attaches token to req using scheme. An empty token sends nothing.
+func setAuth(req *http.Request, scheme, param, token string) {
+	if token == "" {
+		return
+	}
+	param = strings.TrimSpace(param)
+	switch normalizeAuthScheme(scheme) {
+	case AuthBearer:
+		req.Header.Set("Authorization", "Bearer "+token)
+	case AuthHeader:
+		if param == "" {
+			param = defaultAuthHeader
+		}
+		req.Header.Set(param, token)
+	case AuthQuery:
+		if param == "" {
+			param = defaultAuthQuery
+		}
+		q := req.URL.Query()
+		q.Set(param, token)
+		req.URL.RawQuery = q.Encode()
+	case AuthBasic:
+		user, pass, _ := strings.Cut(token, ":")
+		req.SetBasicAuth(user, pass)
+	}
+}
+
+// redactedToken replaces a query token in error messages.
+const redactedToken = "REDACTED"
+
+// redactAuthQuery hides the token of the query scheme in the URL of a
+// *url.Error, whose message the HTTP client builds from the full request
+// URL, so that the key never reaches logs, notifications or pasted text.
+func redactAuthQuery(err error, scheme, param string) error {
+	var ue *url.Error
+	if normalizeAuthScheme(scheme) != AuthQuery || !errors.As(err, &ue) {
+		return err
+	}
+	if param = strings.TrimSpace(param); param == "" {
+		param = defaultAuthQuery
+	}
+	u, perr := url.Parse(ue.URL)
+	if perr != nil {
+		ue.URL = redactedToken
+		return err
+	}
+	q := u.Query()
+	if q.Has(param) {
+		q.Set(param, redactedToken)
+		u.RawQuery = q.Encode()
+		ue.URL = u.String()
+	}
+	return err
+}
+
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", r) {
+			return false
+		}
+	}
+	return true
+}
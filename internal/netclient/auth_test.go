@@ -0,0 +1,78 @@
+package netclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthSchemesAndHeaders(t *testing.T) {
+	var got *http.Request
+	d := &fakeDoer{fn: func(req *http.Request, attempt int) (*http.Response, error) {
+		got = req
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+	}}
+	send := func(opts RetryOptions) *http.Request {
+		t.Helper()
+		opts.MaxRetry = 1
+		if _, err := SendWithRetry(context.Background(), d, "https://example/v1?api-version=1", "tok:en", map[string]interface{}{}, opts); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	req := send(RetryOptions{})
+	if req.Header.Get("Authorization") != "Bearer tok:en" || req.Header.Get("User-Agent") != DefaultUserAgent {
+		t.Fatalf("unexpected default headers: %v", req.Header)
+	}
+	req = send(RetryOptions{Auth: AuthHeader})
+	if req.Header.Get("api-key") != "tok:en" || req.Header.Get("Authorization") != "" {
+		t.Fatalf("header scheme: %v", req.Header)
+	}
+	req = send(RetryOptions{Auth: AuthHeader, AuthParam: "X-Api-Key"})
+	if req.Header.Get("X-Api-Key") != "tok:en" {
+		t.Fatalf("custom header name: %v", req.Header)
+	}
+	req = send(RetryOptions{Auth: AuthQuery})
+	if req.URL.Query().Get("key") != "tok:en" || req.URL.Query().Get("api-version") != "1" {
+		t.Fatalf("query scheme: %s", req.URL)
+	}
+	req = send(RetryOptions{Auth: AuthBasic})
+	if user, pass, ok := req.BasicAuth(); !ok || user != "tok" || pass != "en" {
+		t.Fatalf("basic scheme: %v", req.Header)
+	}
+	req = send(RetryOptions{Auth: AuthNone, UserAgent: "stp-test/2", Headers: map[string]string{"X-Tenant": "acme", "Authorization": "spoof"}})
+	if req.Header.Get("Authorization") != "spoof" || req.Header.Get("X-Tenant") != "acme" || req.Header.Get("User-Agent") != "stp-test/2" {
+		t.Fatalf("custom headers: %v", req.Header)
+	}
+	req = send(RetryOptions{Headers: map[string]string{"Authorization": "spoof"}})
+	if req.Header.Get("Authorization") != "Bearer tok:en" {
+		t.Fatalf("auth scheme must win over a custom Authorization header: %v", req.Header)
+	}
+
+	if err := ValidateAuth("digest", ""); err == nil {
+		t.Fatal("expected unknown scheme to be rejected")
+	}
+	if err := ValidateAuth("header", "bad name"); err == nil {
+		t.Fatal("expected invalid header name to be rejected")
+	}
+}
+
+func TestAuthQueryTokenNotInErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	endpoint := srv.URL + "/v1/chat?api-version=1"
+	srv.Close()
+
+	for _, param := range []string{"", "api_key"} {
+		_, err := SendWithRetry(context.Background(), &http.Client{}, endpoint, "SECRETKEY", map[string]interface{}{}, RetryOptions{MaxRetry: 1, Auth: AuthQuery, AuthParam: param})
+		if err == nil {
+			t.Fatal("expected a transport error")
+		}
+		if msg := err.Error(); strings.Contains(msg, "SECRETKEY") || !strings.Contains(msg, redactedToken) || !strings.Contains(msg, "api-version=1") {
+			t.Fatalf("param %q: token not redacted: %s", param, msg)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package netclient
+
+import (
+	"sync"
+	"time"
+
+	"stp/internal/config"
+)
+
+// Circuit breaker states.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// CircuitBreaker stops sending requests to an endpoint after
+// FailureThreshold consecutive attempts failed with a transport error,
+// timeout or 5xx status. While the circuit is open requests fail at once
+// with a CircuitOpenError; after Cooldown a single trial request is let
+// through (half-open) and its outcome closes or reopens the circuit. It is
+// safe for concurrent use.
+type CircuitBreaker struct {
+	FailureThreshold int
+	Cooldown         time.Duration
+	// Now is a hook for tests; nil means time.Now.
+	Now func() time.Time
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state     string
+	failures  int
+	openUntil time.Time
+	trial     bool // a half-open trial request is in flight
+}
+
+// NewCircuitBreaker builds the breaker described by cfg, or returns nil
+// when CircuitBreakerThreshold is not positive.
+func NewCircuitBreaker(cfg config.Config) *CircuitBreaker {
+	if cfg.CircuitBreakerThreshold <= 0 {
+		return nil
+	}
+	cooldown := secondsToDuration(cfg.CircuitBreakerCooldown)
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+	return &CircuitBreaker{FailureThreshold: cfg.CircuitBreakerThreshold, Cooldown: cooldown}
+}
+
+func (b *CircuitBreaker) now() time.Time {
+	if b.Now != nil {
+		return b.Now()
+	}
+	return time.Now()
+}
+
+// get returns the circuit of endpoint, moving an expired open circuit to
+// half-open. b.mu must be held.
+func (b *CircuitBreaker) get(endpoint string) *circuit {
+	if b.circuits == nil {
+		b.circuits = map[string]*circuit{}
+	}
+	c, ok := b.circuits[endpoint]
+	if !ok {
+		c = &circuit{state: CircuitClosed}
+		b.circuits[endpoint] = c
+	}
+	if c.state == CircuitOpen && !b.now().Before(c.openUntil) {
+		c.state = CircuitHalfOpen
+		c.trial = false
+	}
+	return c
+}
+
+// Allow reports whether a request to endpoint may be sent. A rejected
+// request gets the time the circuit will let a trial through. Every allowed
+// request must be followed by Success, Failure or Release.
+func (b *CircuitBreaker) Allow(endpoint string) (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.get(endpoint)
+	switch c.state {
+	case CircuitOpen:
+		return c.openUntil, false
+	case CircuitHalfOpen:
+		if c.trial {
+			return b.now().Add(b.Cooldown), false
+		}
+		c.trial = true
+	}
+	return time.Time{}, true
+}
+
+// Success closes the circuit of endpoint.
+func (b *CircuitBreaker) Success(endpoint string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.get(endpoint)
+	c.state, c.failures, c.trial = CircuitClosed, 0, false
+}
+
+// Failure counts a failed attempt and opens the circuit once the threshold
+// is reached or the half-open trial failed.
+func (b *CircuitBreaker) Failure(endpoint string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.get(endpoint)
+	c.failures++
+	if c.state == CircuitHalfOpen || c.failures >= b.FailureThreshold {
+		c.state = CircuitOpen
+		c.openUntil = b.now().Add(b.Cooldown)
+		c.trial = false
+	}
+}
+
+// Release gives back an allowed request that was never sent or was
+// canceled, so that a half-open circuit admits another trial.
+func (b *CircuitBreaker) Release(endpoint string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c := b.get(endpoint); c.state == CircuitHalfOpen {
+		c.trial = false
+	}
+}
+
+// openUntil reports whether endpoint's circuit is open and until when.
+func (b *CircuitBreaker) openUntil(endpoint string) (time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.get(endpoint)
+	return c.openUntil, c.state == CircuitOpen
+}
+
+// State returns the state of endpoint's circuit.
+func (b *CircuitBreaker) State(endpoint string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.get(endpoint).state
+}
@@ -0,0 +1,90 @@
+package netclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerFailsFast(t *testing.T) {
+	var hits, healthy int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	now := time.Unix(1000, 0)
+	b := &CircuitBreaker{FailureThreshold: 3, Cooldown: 30 * time.Second, Now: func() time.Time { return now }}
+	slept := 0
+	opts := RetryOptions{MaxRetry: 5, BaseDelay: time.Second, Breaker: b, Sleep: func(context.Context, time.Duration) error {
+		slept++
+		return nil
+	}}
+
+	_, err := SendWithRetry(context.Background(), srv.Client(), srv.URL+"/v1?key=secret", "", map[string]interface{}{}, opts)
+	var open *CircuitOpenError
+	var se *StatusError
+	if !errors.As(err, &open) || open.Attempts != 3 || !errors.As(err, &se) || se.StatusCode != 503 {
+		t.Fatalf("expected circuit to open after 3 attempts, got %v", err)
+	}
+	if hits != 3 || slept != 2 {
+		t.Fatalf("hits=%d slept=%d, want 3 and 2", hits, slept)
+	}
+	if open.Endpoint != srv.Listener.Addr().String() || !open.RetryAt.Equal(now.Add(30*time.Second)) {
+		t.Fatalf("unexpected error fields %+v", open)
+	}
+
+	// While open nothing is sent.
+	_, err = SendWithRetry(context.Background(), srv.Client(), srv.URL+"/v1?key=secret", "", map[string]interface{}{}, opts)
+	if !errors.As(err, &open) || open.Attempts != 0 || hits != 3 {
+		t.Fatalf("expected fail fast, got %v (hits %d)", err, hits)
+	}
+
+	// After the cooldown one trial closes the circuit again.
+	now = now.Add(31 * time.Second)
+	atomic.StoreInt32(&healthy, 1)
+	if _, err := SendWithRetry(context.Background(), srv.Client(), srv.URL+"/v1?key=secret", "", map[string]interface{}{}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if st := b.State(srv.URL + "/v1?key=secret"); st != CircuitClosed {
+		t.Fatalf("expected closed circuit, got %s", st)
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := &CircuitBreaker{FailureThreshold: 1, Cooldown: 10 * time.Second, Now: func() time.Time { return now }}
+	const ep = "https://api.example/v1"
+	if _, ok := b.Allow(ep); !ok {
+		t.Fatal("closed circuit must allow")
+	}
+	b.Failure(ep)
+	if _, ok := b.Allow(ep); ok || b.State(ep) != CircuitOpen {
+		t.Fatal("expected open circuit")
+	}
+
+	now = now.Add(10 * time.Second)
+	if _, ok := b.Allow(ep); !ok || b.State(ep) != CircuitHalfOpen {
+		t.Fatal("expected a half-open trial")
+	}
+	if _, ok := b.Allow(ep); ok {
+		t.Fatal("only one trial may be in flight")
+	}
+	b.Release(ep)
+	if _, ok := b.Allow(ep); !ok {
+		t.Fatal("a released trial must admit another")
+	}
+	b.Failure(ep)
+	if retryAt, ok := b.Allow(ep); ok || !retryAt.Equal(now.Add(10*time.Second)) {
+		t.Fatalf("failed trial must reopen the circuit, got %v %v", retryAt, ok)
+	}
+}
@@ -1,7 +1,8 @@
 package netclient
 
 import (
-	"crypto/tls"
+	"context"
+	"net"
 	"net/http"
 	"time"
 
@@ -14,21 +15,46 @@ type Doer interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-func New(cfg config.Config) (*http.Client, *http.Transport) {
+func New(cfg config.Config) (*http.Client, *http.Transport, error) {
+	tlsConfig, err := NewTLSConfig(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout:   secondsToDuration(cfg.ConnectTimeout),
+		KeepAlive: 30 * time.Second,
+	}
 	tr := &http.Transport{
+		Proxy:               newProxyResolver(ProxySettings{Proxy: cfg.Proxy, NoProxy: cfg.NoProxy}).Proxy,
+		DialContext:         dialContext(dialer),
+		TLSHandshakeTimeout: secondsToDuration(cfg.TLSHandshakeTimeout),
 		MaxIdleConns:        100,
 		MaxIdleConnsPerHost: 100,
 		IdleConnTimeout:     90 * time.Second,
-	}
-	if !cfg.VerifySSL {
-		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		TLSClientConfig:     tlsConfig,
 	}
 	if cfg.EnableHTTP2 {
 		_ = http2.ConfigureTransport(tr)
 	}
+	// The request timeout is applied per attempt through
+	// RetryOptions.AttemptTimeout so that entries can override it.
 	cli := &http.Client{
 		Transport: tr,
-		Timeout:   time.Duration(cfg.RequestTimeout) * time.Second,
 	}
-	return cli, tr
+	return cli, tr, nil
+}
+
+type dialTimeoutKey struct{}
+
+// dialContext dials with d, honoring a per-request ConnectTimeout carried in
+// the request context.
+func dialContext(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if v, ok := ctx.Value(dialTimeoutKey{}).(time.Duration); ok && v > 0 {
+			dd := *d
+			dd.Timeout = v
+			return dd.DialContext(ctx, network, addr)
+		}
+		return d.DialContext(ctx, network, addr)
+	}
 }
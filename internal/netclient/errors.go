@@ -0,0 +1,123 @@
+package netclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StatusError is returned when the endpoint answered with a non-2xx status
+// on the last attempt.
+type StatusError struct {
+	StatusCode int
+	// Message is the provider's error message (error.message and friends)
+	// when the body carries one.
+	Message    string
+	Body       string
+	RetryAfter time.Duration
+	Attempts   int
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("status %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Body)
+}
+
+// TimeoutError is returned when the last attempt or the caller's deadline
+// timed out.
+type TimeoutError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("request timed out after %d attempt(s): %v", e.Attempts, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+// CanceledError is returned when the caller canceled the request, e.g. via
+// StopTaskHotkey.
+type CanceledError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("request canceled: %v", e.Err)
+}
+
+func (e *CanceledError) Unwrap() error { return e.Err }
+
+// TransportError wraps network level failures (refused, reset, DNS ...).
+type TransportError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("transport error after %d attempt(s): %v", e.Attempts, e.Err)
+}
+
+func (e *TransportError) Unwrap() error { return e.Err }
+
+// CircuitOpenError is returned without sending a request while the circuit
+// breaker of Endpoint (host only) is open. Err is the failure of the last
+// attempt of this request, if any.
+type CircuitOpenError struct {
+	Endpoint string
+	RetryAt  time.Time
+	Attempts int
+	Err      error
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("endpoint unavailable: circuit open for %s until %s", e.Endpoint, e.RetryAt.Format("15:04:05"))
+}
+
+func (e *CircuitOpenError) Unwrap() error { return e.Err }
+
+// ContextError converts a finished caller context into CanceledError or TimeoutError.
+func ContextError(ctx context.Context, attempts int) error {
+	err := ctx.Err()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &TimeoutError{Attempts: attempts, Err: err}
+	}
+	return &CanceledError{Attempts: attempts, Err: err}
+}
+
+// classifyError wraps a failed attempt's transport error.
+func classifyError(err error, attempts int) error {
+	if isTimeout(err) {
+		return &TimeoutError{Attempts: attempts, Err: err}
+	}
+	return &TransportError{Attempts: attempts, Err: err}
+}
+
+// ProviderMessage extracts a human readable error message from common
+// provider error bodies such as {"error":{"message":"..."}}.
+func ProviderMessage(body []byte) string {
+	var root map[string]interface{}
+	if err := json.Unmarshal(body, &root); err != nil {
+		return ""
+	}
+	switch e := root["error"].(type) {
+	case string:
+		return strings.TrimSpace(e)
+	case map[string]interface{}:
+		if m, ok := e["message"].(string); ok {
+			return strings.TrimSpace(m)
+		}
+	}
+	for _, k := range []string{"message", "detail"} {
+		if m, ok := root[k].(string); ok {
+			return strings.TrimSpace(m)
+		}
+	}
+	return ""
+}
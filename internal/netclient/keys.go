@@ -0,0 +1,163 @@
+package netclient
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key rotation modes.
+const (
+	RotateRoundRobin = "round-robin"
+	RotateFailover   = "failover"
+)
+
+// quotaPattern recognizes provider messages about an exhausted quota or
+// billing problem, which no retry with the same key can fix.
+var quotaPattern = regexp.MustCompile(`(?i)quota|billing|credit balance|insufficient_quota`)
+
+// KeyRing rotates requests over several API keys. In round-robin mode each
+// request starts with the next key; in failover mode requests stay on the
+// first usable key. A key rejected with 401/403, 429 or a quota error is
+// benched and the attempt is repeated with another key right away. Keys are
+// only ever identified by their 1-based index. It is safe for concurrent use.
+type KeyRing struct {
+	keys  []string
+	mode  string
+	bench time.Duration
+
+	// Now and Logf are hooks; nil means time.Now and no logging.
+	Now  func() time.Time
+	Logf func(format string, args ...interface{})
+
+	mu           sync.Mutex
+	next         int
+	benchedUntil []time.Time
+}
+
+// NewKeyRing builds a key ring over keys. bench is how long a rejected key
+// is skipped (default 60s, or the Retry-After of a 429 when longer).
+func NewKeyRing(keys []string, mode string, bench time.Duration) (*KeyRing, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no API keys")
+	}
+	if err := ValidateTokenRotation(mode); err != nil {
+		return nil, err
+	}
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode == "" {
+		mode = RotateRoundRobin
+	}
+	if bench <= 0 {
+		bench = time.Minute
+	}
+	return &KeyRing{
+		keys:         append([]string(nil), keys...),
+		mode:         mode,
+		bench:        bench,
+		benchedUntil: make([]time.Time, len(keys)),
+	}, nil
+}
+
+// ValidateTokenRotation checks a TokenRotation value; empty means
+// round-robin.
+func ValidateTokenRotation(mode string) error {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", RotateRoundRobin, RotateFailover:
+		return nil
+	}
+	return fmt.Errorf("unknown TokenRotation %q (want round-robin or failover)", mode)
+}
+
+// Len returns the number of keys.
+func (r *KeyRing) Len() int { return len(r.keys) }
+
+func (r *KeyRing) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+func (r *KeyRing) logf(format string, args ...interface{}) {
+	if r.Logf != nil {
+		r.Logf(format, args...)
+	}
+}
+
+// pick returns the index and value of the key for the next attempt,
+// skipping benched keys and those in tried. When every key is benched the
+// one that comes back first is used.
+func (r *KeyRing) pick(tried map[int]bool) (int, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	start := 0
+	if r.mode == RotateRoundRobin && len(tried) == 0 {
+		start = r.next % len(r.keys)
+		r.next++
+	}
+	for pass := 0; pass < 2; pass++ {
+		for n := 0; n < len(r.keys); n++ {
+			i := (start + n) % len(r.keys)
+			// The first pass wants an untried key, the second any usable one.
+			if r.benchedUntil[i].After(now) || (pass == 0 && tried[i]) {
+				continue
+			}
+			return i, r.keys[i]
+		}
+	}
+	best := 0
+	for i, t := range r.benchedUntil {
+		if t.Before(r.benchedUntil[best]) {
+			best = i
+		}
+	}
+	return best, r.keys[best]
+}
+
+// hasAlternative reports whether a usable key not in tried exists.
+func (r *KeyRing) hasAlternative(tried map[int]bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	for i := range r.keys {
+		if !tried[i] && !r.benchedUntil[i].After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyRejected reports why the response of an attempt disqualifies its key, or
+// "" when the key is fine.
+func keyRejected(status int, body []byte) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return fmt.Sprintf("status %d", status)
+	case status == http.StatusTooManyRequests:
+		if quotaPattern.Match(body) {
+			return "quota exhausted"
+		}
+		return "rate limited"
+	case status >= 400 && quotaPattern.Match(body):
+		return "quota exhausted"
+	}
+	return ""
+}
+
+// benchKey takes key i out of rotation for the bench duration, or for
+// retryAfter when that is longer.
+func (r *KeyRing) benchKey(i int, reason string, retryAfter time.Duration) {
+	d := r.bench
+	if retryAfter > d {
+		d = retryAfter
+	}
+	r.mu.Lock()
+	r.benchedUntil[i] = r.now().Add(d)
+	r.mu.Unlock()
+	r.logf("[keys] key #%d benched for %v (%s)", i+1, d, reason)
+}
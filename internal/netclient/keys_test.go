@@ -0,0 +1,109 @@
+package netclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyRingRotatesAndBenches(t *testing.T) {
+	var mu sync.Mutex
+	var used []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		mu.Lock()
+		used = append(used, key)
+		mu.Unlock()
+		switch key {
+		case "k2":
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error":{"message":"You exceeded your current quota","code":"insufficient_quota"}}`))
+		case "k3":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	now := time.Unix(1000, 0)
+	ring, err := NewKeyRing([]string{"k1", "k2", "k3"}, "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs []string
+	ring.Now = func() time.Time { return now }
+	ring.Logf = func(f string, args ...interface{}) { logs = append(logs, fmt.Sprintf(f, args...)) }
+	opts := RetryOptions{MaxRetry: 1, Keys: ring}
+	for i := 0; i < 3; i++ {
+		if _, err := SendWithRetry(context.Background(), srv.Client(), srv.URL, "", map[string]interface{}{}, opts); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	// Request 2 starts at k2 (quota) and switches to k1; request 3 starts
+	// at k3 (401) and switches to k1 as well.
+	if got := strings.Join(used, ","); got != "k1,k2,k1,k3,k1" {
+		t.Fatalf("unexpected key order %s", got)
+	}
+	var attempts, benched []string
+	for _, l := range logs {
+		if strings.Contains(l, "benched") {
+			benched = append(benched, l)
+		} else {
+			attempts = append(attempts, l[strings.LastIndex(l, "#"):])
+		}
+	}
+	if len(benched) != 2 || !strings.Contains(benched[0], "key #2 benched for 1m0s (quota exhausted)") || !strings.Contains(benched[1], "key #3") {
+		t.Fatalf("unexpected logs %q", logs)
+	}
+	if got := strings.Join(attempts, ","); got != "#1,#2,#1,#3,#1" {
+		t.Fatalf("every attempt must log its key index, got %q", logs)
+	}
+	for _, l := range logs {
+		if strings.Contains(l, "k2") || strings.Contains(l, "k3") {
+			t.Fatalf("log leaks a key: %s", l)
+		}
+	}
+
+	// Once the bench is over the keys are used again.
+	if i, _ := ring.pick(nil); i != 0 {
+		t.Fatalf("benched keys must be skipped, got key #%d", i+1)
+	}
+	now = now.Add(2 * time.Minute)
+	seen := map[int]bool{}
+	for n := 0; n < 3; n++ {
+		i, _ := ring.pick(nil)
+		seen[i] = true
+	}
+	if len(seen) != 3 {
+		t.Fatalf("expected every key back in rotation, got %v", seen)
+	}
+}
+
+func TestKeyRingFailoverAllBenched(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	ring, err := NewKeyRing([]string{"a", "b"}, RotateFailover, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = SendWithRetry(context.Background(), srv.Client(), srv.URL, "", map[string]interface{}{}, RetryOptions{MaxRetry: 1, Keys: ring})
+	se, ok := err.(*StatusError)
+	if !ok || se.StatusCode != http.StatusTooManyRequests || se.Attempts != 1 {
+		t.Fatalf("expected a 429 after both keys, got %v", err)
+	}
+	if ring.hasAlternative(nil) {
+		t.Fatal("both keys should be benched")
+	}
+	if _, err := NewKeyRing([]string{"a"}, "random", 0); err == nil {
+		t.Fatal("expected unknown rotation to be rejected")
+	}
+}
@@ -0,0 +1,206 @@
+package netclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"stp/internal/config"
+)
+
+// TokenSource supplies the credential sent with each attempt. Invalidate
+// drops token from the cache after the endpoint rejected it.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+	Invalidate(token string)
+}
+
+// defaultExpiryDelta is how long before expiry a cached token is refreshed.
+const defaultExpiryDelta = 30 * time.Second
+
+// OAuth2ClientCredentials fetches access tokens with the OAuth2 client
+// credentials grant (RFC 6749 section 4.4) and caches them until shortly
+// before they expire. A token without expires_in is kept until invalidated.
+type OAuth2ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// Params are extra form parameters such as audience or resource.
+	Params map[string]string
+	// SecretInBody sends the client credentials as form fields
+	// (client_secret_post) instead of HTTP Basic auth.
+	SecretInBody bool
+	Client       Doer
+	// ExpiryDelta defaults to 30s, capped at half of the token lifetime.
+	ExpiryDelta time.Duration
+	Now         func() time.Time
+
+	mu        sync.Mutex
+	token     string
+	refreshAt time.Time // zero means no expiry
+}
+
+// NewOAuth2ClientCredentials builds a token source from cfg that fetches
+// tokens through client.
+func NewOAuth2ClientCredentials(cfg config.OAuth2Config, client Doer) (*OAuth2ClientCredentials, error) {
+	if err := ValidateOAuth2(cfg); err != nil {
+		return nil, err
+	}
+	return &OAuth2ClientCredentials{
+		TokenURL:     strings.TrimSpace(cfg.TokenURL),
+		ClientID:     strings.TrimSpace(cfg.ClientID),
+		ClientSecret: cfg.ClientSecret,
+		Scopes:       cfg.Scopes,
+		Params:       cfg.Params,
+		SecretInBody: strings.EqualFold(strings.TrimSpace(cfg.AuthStyle), "body"),
+		Client:       client,
+	}, nil
+}
+
+// ValidateOAuth2 reports whether cfg describes a usable token endpoint.
+func ValidateOAuth2(cfg config.OAuth2Config) error {
+	u, err := url.Parse(strings.TrimSpace(cfg.TokenURL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid OAuth2 TokenURL %q", cfg.TokenURL)
+	}
+	if strings.TrimSpace(cfg.ClientID) == "" {
+		return fmt.Errorf("OAuth2 ClientID empty")
+	}
+	switch strings.ToLower(strings.TrimSpace(cfg.AuthStyle)) {
+	case "", "basic", "body":
+	default:
+		return fmt.Errorf("unknown OAuth2 AuthStyle %q (want basic or body)", cfg.AuthStyle)
+	}
+	return nil
+}
+
+func (s *OAuth2ClientCredentials) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// Token returns the cached token or fetches a new one. Concurrent callers
+// wait for a single fetch.
+func (s *OAuth2ClientCredentials) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && (s.refreshAt.IsZero() || s.now().Before(s.refreshAt)) {
+		return s.token, nil
+	}
+	token, lifetime, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token = token
+	s.refreshAt = time.Time{}
+	if lifetime > 0 {
+		delta := s.ExpiryDelta
+		if delta <= 0 {
+			delta = defaultExpiryDelta
+		}
+		if delta > lifetime/2 {
+			delta = lifetime / 2
+		}
+		s.refreshAt = s.now().Add(lifetime - delta)
+	}
+	return token, nil
+}
+
+func (s *OAuth2ClientCredentials) Invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == token {
+		s.token = ""
+		s.refreshAt = time.Time{}
+	}
+}
+
+type tokenResponse struct {
+	AccessToken      string          `json:"access_token"`
+	ExpiresIn        json.RawMessage `json:"expires_in"`
+	Error            string          `json:"error"`
+	ErrorDescription string          `json:"error_description"`
+}
+
+func (s *OAuth2ClientCredentials) fetch(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.Scopes, " "))
+	}
+	for k, v := range s.Params {
+		form.Set(k, v)
+	}
+	if s.SecretInBody {
+		form.Set("client_id", s.ClientID)
+		form.Set("client_secret", s.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !s.SecretInBody {
+		req.SetBasicAuth(url.QueryEscape(s.ClientID), url.QueryEscape(s.ClientSecret))
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("OAuth2 token request: %w", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return "", 0, fmt.Errorf("OAuth2 token request: %w", err)
+	}
+
+	var tr tokenResponse
+	_ = json.Unmarshal(body, &tr)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || tr.AccessToken == "" {
+		msg := tr.ErrorDescription
+		if msg == "" {
+			msg = tr.Error
+		}
+		if msg == "" {
+			msg = "no access_token in response"
+		}
+		status := resp.StatusCode
+		if status >= 200 && status < 300 {
+			// A 2xx without a token is a broken endpoint, not a success.
+			status = http.StatusBadGateway
+		}
+		return "", 0, &StatusError{
+			StatusCode: status,
+			Message:    "OAuth2 token endpoint: " + msg,
+			Body:       string(body),
+		}
+	}
+	return tr.AccessToken, parseExpiresIn(tr.ExpiresIn), nil
+}
+
+// parseExpiresIn accepts a number or a numeric string (some identity
+// providers quote it).
+func parseExpiresIn(raw json.RawMessage) time.Duration {
+	s := strings.Trim(strings.TrimSpace(string(raw)), `"`)
+	if s == "" || s == "null" {
+		return 0
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return time.Duration(n * float64(time.Second))
+}
@@ -0,0 +1,167 @@
+package netclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"stp/internal/config"
+)
+
+// tokenServer is a client credentials endpoint stand-in that issues
+// tok-1, tok-2, ... and an API that only accepts the latest token.
+type tokenServer struct {
+	mu      sync.Mutex
+	issued  int
+	revoked bool
+	form    map[string]string
+	user    string
+}
+
+func (ts *tokenServer) current() string {
+	return fmt.Sprintf("tok-%d", ts.issued)
+}
+
+func (ts *tokenServer) handler(expiresIn string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		_ = r.ParseForm()
+		ts.form = map[string]string{}
+		for k := range r.PostForm {
+			ts.form[k] = r.PostForm.Get(k)
+		}
+		ts.user, _, _ = r.BasicAuth()
+		if ts.user != "stp" && ts.form["client_id"] != "stp" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = io.WriteString(w, `{"error":"invalid_client","error_description":"unknown client"}`)
+			return
+		}
+		ts.issued++
+		ts.revoked = false
+		fmt.Fprintf(w, `{"access_token":%q,"token_type":"Bearer","expires_in":%s}`, ts.current(), expiresIn)
+	})
+	mux.HandleFunc("/v1/chat", func(w http.ResponseWriter, r *http.Request) {
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		if ts.revoked || r.Header.Get("Authorization") != "Bearer "+ts.current() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = io.WriteString(w, `{"ok":true}`)
+	})
+	return mux
+}
+
+func TestOAuth2CachesAndRefreshesBeforeExpiry(t *testing.T) {
+	ts := &tokenServer{}
+	srv := httptest.NewServer(ts.handler("3600"))
+	defer srv.Close()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	src, err := NewOAuth2ClientCredentials(config.OAuth2Config{
+		TokenURL:     srv.URL + "/token",
+		ClientID:     "stp",
+		ClientSecret: "pw",
+		Scopes:       []string{"llm.invoke", "llm.read"},
+		Params:       map[string]string{"audience": "gw"},
+	}, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.Now = func() time.Time { return now }
+	opts := RetryOptions{MaxRetry: 1, TokenSource: src}
+	for i := 0; i < 3; i++ {
+		if _, err := SendWithRetry(context.Background(), srv.Client(), srv.URL+"/v1/chat", "", map[string]interface{}{}, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ts.issued != 1 {
+		t.Fatalf("token should be cached, fetched %d times", ts.issued)
+	}
+	if ts.form["grant_type"] != "client_credentials" || ts.form["scope"] != "llm.invoke llm.read" || ts.form["audience"] != "gw" || ts.user != "stp" {
+		t.Fatalf("unexpected token request: user=%q form=%v", ts.user, ts.form)
+	}
+
+	// 30s before expiry the token is refreshed.
+	now = now.Add(3600*time.Second - 29*time.Second)
+	if _, err := SendWithRetry(context.Background(), srv.Client(), srv.URL+"/v1/chat", "", map[string]interface{}{}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if ts.issued != 2 {
+		t.Fatalf("token should be refreshed before expiry, fetched %d times", ts.issued)
+	}
+}
+
+func TestOAuth2RefreshesOnceOn401(t *testing.T) {
+	ts := &tokenServer{}
+	srv := httptest.NewServer(ts.handler(`"3600"`))
+	defer srv.Close()
+	src, err := NewOAuth2ClientCredentials(config.OAuth2Config{TokenURL: srv.URL + "/token", ClientID: "stp", AuthStyle: "body"}, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := RetryOptions{MaxRetry: 1, TokenSource: src}
+	if _, err := SendWithRetry(context.Background(), srv.Client(), srv.URL+"/v1/chat", "", map[string]interface{}{}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if ts.form["client_id"] != "stp" || ts.user != "" {
+		t.Fatalf("body auth style should send client_id as a form field: %v", ts.form)
+	}
+
+	// The gateway rotated its keys: the cached token is rejected once.
+	ts.mu.Lock()
+	ts.revoked = true
+	ts.mu.Unlock()
+	if _, err := SendWithRetry(context.Background(), srv.Client(), srv.URL+"/v1/chat", "", map[string]interface{}{}, opts); err != nil {
+		t.Fatalf("401 should trigger a refresh within MaxRetry=1: %v", err)
+	}
+	if ts.issued != 2 {
+		t.Fatalf("expected one refresh, fetched %d times", ts.issued)
+	}
+
+	// A token the API keeps rejecting fails after a single refresh.
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer api.Close()
+	_, err = SendWithRetry(context.Background(), srv.Client(), api.URL, "", map[string]interface{}{}, opts)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != 401 || statusErr.Attempts != 1 || ts.issued != 3 {
+		t.Fatalf("expected one refresh then 401, got %v (issued=%d)", err, ts.issued)
+	}
+}
+
+func TestOAuth2TokenEndpointErrors(t *testing.T) {
+	ts := &tokenServer{}
+	srv := httptest.NewServer(ts.handler("60"))
+	defer srv.Close()
+	src, err := NewOAuth2ClientCredentials(config.OAuth2Config{TokenURL: srv.URL + "/token", ClientID: "intruder"}, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = SendWithRetry(context.Background(), srv.Client(), srv.URL+"/v1/chat", "", map[string]interface{}{}, RetryOptions{MaxRetry: 3, TokenSource: src})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != 401 || !strings.Contains(statusErr.Message, "unknown client") || statusErr.Attempts != 1 {
+		t.Fatalf("expected non-retried token endpoint error, got %#v", err)
+	}
+
+	for _, bad := range []config.OAuth2Config{
+		{TokenURL: "", ClientID: "x"},
+		{TokenURL: "ftp://sso/token", ClientID: "x"},
+		{TokenURL: "https://sso/token"},
+		{TokenURL: "https://sso/token", ClientID: "x", AuthStyle: "jwt"},
+	} {
+		if err := ValidateOAuth2(bad); err == nil {
+			t.Fatalf("expected %+v to be rejected", bad)
+		}
+	}
+}
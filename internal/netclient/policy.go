@@ -0,0 +1,159 @@
+package netclient
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"stp/internal/config"
+)
+
+// DefaultRetryableStatus lists the HTTP statuses that are worth retrying.
+var DefaultRetryableStatus = []int{408, 425, 429, 500, 502, 503, 504}
+
+// RetryPolicy decides which failures are retried and how long to wait.
+type RetryPolicy struct {
+	// RetryableStatus lists HTTP statuses that are retried; others fail at once.
+	RetryableStatus []int
+	// RetryNetworkErrors retries transport failures (refused, reset, DNS ...).
+	RetryNetworkErrors bool
+	// RetryTimeouts retries attempts that ran into a timeout.
+	RetryTimeouts bool
+	// MaxDelay caps a single backoff; zero means uncapped up to maxBackoff.
+	// A Retry-After longer than MaxDelay ends the retries instead of waiting.
+	MaxDelay time.Duration
+	// Jitter picks each delay uniformly in [0, backoff) ("full jitter").
+	Jitter bool
+	// Deadline bounds the whole retry sequence measured from the first
+	// attempt; a retry that could not start before it is not attempted.
+	Deadline time.Duration
+
+	// Rand and Now are hooks for tests; nil means math/rand and time.Now.
+	Rand func() float64
+	Now  func() time.Time
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		RetryableStatus:    append([]int(nil), DefaultRetryableStatus...),
+		RetryNetworkErrors: true,
+		RetryTimeouts:      true,
+		MaxDelay:           30 * time.Second,
+		Jitter:             true,
+	}
+}
+
+// NewRetryPolicy builds the retry policy described by cfg.
+func NewRetryPolicy(cfg config.Config) *RetryPolicy {
+	p := DefaultRetryPolicy()
+	if cfg.RetryableStatusCodes != nil {
+		p.RetryableStatus = append([]int(nil), cfg.RetryableStatusCodes...)
+	}
+	p.RetryNetworkErrors = cfg.RetryOnNetworkError
+	p.RetryTimeouts = cfg.RetryOnTimeout
+	p.MaxDelay = secondsToDuration(cfg.RetryMaxDelay)
+	p.Deadline = secondsToDuration(cfg.RetryDeadline)
+	p.Jitter = cfg.RetryJitter
+	return p
+}
+
+func (p *RetryPolicy) retryableStatus(code int) bool {
+	for _, c := range p.RetryableStatus {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retryableError classifies a transport error of one attempt; ctx is the
+// caller's context, so its cancellation is never retried.
+func (p *RetryPolicy) retryableError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if isTimeout(err) {
+		return p.RetryTimeouts
+	}
+	return p.RetryNetworkErrors
+}
+
+// maxBackoff caps every backoff, so that doubling cannot overflow when
+// MaxDelay is zero and MaxRetry is large.
+const maxBackoff = time.Hour
+
+// backoff returns the delay before the given retry (1 for the first retry).
+func (p *RetryPolicy) backoff(base time.Duration, retry int) time.Duration {
+	limit := maxBackoff
+	if p.MaxDelay > 0 && p.MaxDelay < limit {
+		limit = p.MaxDelay
+	}
+	d := base
+	for i := 1; i < retry && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	if p.Jitter && d > 0 {
+		d = time.Duration(p.random() * float64(d))
+	}
+	return d
+}
+
+func (p *RetryPolicy) random() float64 {
+	if p.Rand != nil {
+		return p.Rand()
+	}
+	return rand.Float64()
+}
+
+func (p *RetryPolicy) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP-date.
+func parseRetryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	d := t.Sub(now)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+func secondsToDuration(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
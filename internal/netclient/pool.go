@@ -0,0 +1,294 @@
+package netclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoint selection strategies.
+const (
+	StrategyPriority     = "priority"
+	StrategyRoundRobin   = "round-robin"
+	StrategyLeastLatency = "least-latency"
+)
+
+// Endpoint is one member of an EndpointPool. An empty Token falls back to
+// the request's token.
+type Endpoint struct {
+	URL            string
+	Token          string
+	Priority       int
+	HealthCheckURL string
+}
+
+// PoolOptions tune the health tracking of an EndpointPool.
+type PoolOptions struct {
+	Strategy string
+	// FailureThreshold consecutive failures mark an endpoint down (default 1).
+	FailureThreshold int
+	// Cooldown is how long a down endpoint is skipped (default 30s).
+	Cooldown time.Duration
+	Now      func() time.Time
+}
+
+type endpointState struct {
+	Endpoint
+	failures  int
+	downUntil time.Time
+	// probeDown is set when a probe, not a request, took the endpoint
+	// down; only then may a probe bring it back before the cooldown ends.
+	probeDown bool
+	latency   time.Duration // EWMA of successful attempts, 0 = unknown
+}
+
+// EndpointPool spreads requests over equivalent endpoints, skipping those
+// that failed recently. It is safe for concurrent use.
+type EndpointPool struct {
+	strategy  string
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	endpoints []*endpointState
+	next      int
+}
+
+// NewEndpointPool validates eps and opts and builds a pool.
+func NewEndpointPool(eps []Endpoint, opts PoolOptions) (*EndpointPool, error) {
+	if len(eps) == 0 {
+		return nil, fmt.Errorf("endpoint pool is empty")
+	}
+	p := &EndpointPool{
+		strategy:  strings.ToLower(strings.TrimSpace(opts.Strategy)),
+		threshold: opts.FailureThreshold,
+		cooldown:  opts.Cooldown,
+		now:       opts.Now,
+	}
+	switch p.strategy {
+	case "":
+		p.strategy = StrategyPriority
+	case StrategyPriority, StrategyRoundRobin, StrategyLeastLatency:
+	default:
+		return nil, fmt.Errorf("unknown endpoint strategy %q (want priority, round-robin or least-latency)", opts.Strategy)
+	}
+	if p.threshold <= 0 {
+		p.threshold = 1
+	}
+	if p.cooldown <= 0 {
+		p.cooldown = 30 * time.Second
+	}
+	if p.now == nil {
+		p.now = time.Now
+	}
+	for i, e := range eps {
+		e.URL = strings.TrimSpace(e.URL)
+		if u, err := url.Parse(e.URL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("endpoint %d: invalid URL %q", i, e.URL)
+		}
+		p.endpoints = append(p.endpoints, &endpointState{Endpoint: e})
+	}
+	return p, nil
+}
+
+// Len returns the number of endpoints in the pool.
+func (p *EndpointPool) Len() int { return len(p.endpoints) }
+
+// pick selects the endpoint of the next attempt. Healthy endpoints not yet
+// tried by this request come first, then any healthy endpoint, then the
+// endpoint that comes back soonest.
+func (p *EndpointPool) pick(tried map[string]bool) Endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	var healthy, fresh []int
+	for i, e := range p.endpoints {
+		if e.downUntil.After(now) {
+			continue
+		}
+		healthy = append(healthy, i)
+		if !tried[e.URL] {
+			fresh = append(fresh, i)
+		}
+	}
+	cands := fresh
+	if len(cands) == 0 {
+		cands = healthy
+	}
+	if len(cands) == 0 {
+		best := 0
+		for i, e := range p.endpoints {
+			if e.downUntil.Before(p.endpoints[best].downUntil) {
+				best = i
+			}
+		}
+		return p.endpoints[best].Endpoint
+	}
+
+	best := cands[0]
+	switch p.strategy {
+	case StrategyRoundRobin:
+		best = cands[p.next%len(cands)]
+		p.next++
+	case StrategyLeastLatency:
+		// Unmeasured endpoints win so that every endpoint gets measured.
+		for _, i := range cands[1:] {
+			if l, b := p.endpoints[i].latency, p.endpoints[best].latency; b != 0 && (l == 0 || l < b) {
+				best = i
+			}
+		}
+	default:
+		for _, i := range cands[1:] {
+			if p.endpoints[i].Priority < p.endpoints[best].Priority {
+				best = i
+			}
+		}
+	}
+	return p.endpoints[best].Endpoint
+}
+
+// hasAlternative reports whether a healthy endpoint not in tried exists.
+func (p *EndpointPool) hasAlternative(tried map[string]bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	for _, e := range p.endpoints {
+		if !e.downUntil.After(now) && !tried[e.URL] {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *EndpointPool) state(rawURL string) *endpointState {
+	for _, e := range p.endpoints {
+		if e.URL == rawURL {
+			return e
+		}
+	}
+	return nil
+}
+
+// ReportSuccess marks the endpoint healthy and records its latency.
+func (p *EndpointPool) ReportSuccess(rawURL string, latency time.Duration) {
+	p.reportSuccess(rawURL, latency, false)
+}
+
+// reportSuccess leaves an endpoint that requests took down alone when probe
+// is set, until its cooldown ends.
+func (p *EndpointPool) reportSuccess(rawURL string, latency time.Duration, probe bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := p.state(rawURL)
+	if e == nil || probe && !e.probeDown && e.downUntil.After(p.now()) {
+		return
+	}
+	e.failures = 0
+	e.downUntil = time.Time{}
+	e.probeDown = false
+	if latency > 0 {
+		if e.latency == 0 {
+			e.latency = latency
+		} else {
+			e.latency = (e.latency*7 + latency*3) / 10
+		}
+	}
+}
+
+// ReportFailure counts a failure and takes the endpoint out of rotation
+// for the cooldown once the threshold is reached.
+func (p *EndpointPool) ReportFailure(rawURL string) {
+	p.reportFailure(rawURL, false)
+}
+
+func (p *EndpointPool) reportFailure(rawURL string, probe bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := p.state(rawURL)
+	if e == nil {
+		return
+	}
+	now := p.now()
+	e.failures++
+	if e.failures >= p.threshold {
+		// A failed probe extends the cooldown of a request failure but
+		// does not make it clearable by the next probe.
+		e.probeDown = probe && (e.probeDown || !e.downUntil.After(now))
+		e.downUntil = now.Add(p.cooldown)
+	}
+}
+
+// Healthy reports whether rawURL is currently in rotation.
+func (p *EndpointPool) Healthy(rawURL string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := p.state(rawURL)
+	return e != nil && !e.downUntil.After(p.now())
+}
+
+// Probe checks every endpoint once with a GET to its HealthCheckURL (or its
+// URL). A response below 500 from a HealthCheckURL counts as healthy; the
+// endpoint URL itself has to answer 2xx, since API endpoints usually reject
+// GET with 4xx whether or not they work. Network errors and 5xx count as
+// failures, other responses are ignored. Proxy settings are taken from ctx
+// as for requests.
+func (p *EndpointPool) Probe(ctx context.Context, doer Doer, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, e := range p.endpoints {
+		wg.Add(1)
+		go func(e Endpoint) {
+			defer wg.Done()
+			target := e.HealthCheckURL
+			if target == "" {
+				target = e.URL
+			}
+			pctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			req, err := http.NewRequestWithContext(pctx, http.MethodGet, target, nil)
+			if err != nil {
+				return
+			}
+			resp, err := doer.Do(req)
+			if err == nil {
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			switch {
+			case err != nil || resp.StatusCode >= 500:
+				p.reportFailure(e.URL, true)
+			case e.HealthCheckURL != "" || resp.StatusCode/100 == 2:
+				p.reportSuccess(e.URL, time.Since(start), true)
+			}
+		}(e.Endpoint)
+	}
+	wg.Wait()
+}
+
+// StartProbing probes the pool every interval until ctx is done. Each probe
+// times out after the interval, at most 10s.
+func (p *EndpointPool) StartProbing(ctx context.Context, doer Doer, interval time.Duration) {
+	timeout := interval
+	if timeout > 10*time.Second {
+		timeout = 10 * time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			p.Probe(ctx, doer, timeout)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package netclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolFailsOverWithinRetryBudget(t *testing.T) {
+	var downHits, upHits int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&downHits, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+	var gotAuth atomic.Value
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&upHits, 1)
+		gotAuth.Store(r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer up.Close()
+
+	pool, err := NewEndpointPool([]Endpoint{
+		{URL: down.URL, Priority: 0},
+		{URL: up.URL, Token: "tok-b", Priority: 1},
+	}, PoolOptions{Cooldown: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slept := 0
+	opts := RetryOptions{MaxRetry: 2, BaseDelay: time.Second, Pool: pool, Sleep: func(context.Context, time.Duration) error {
+		slept++
+		return nil
+	}}
+	body, err := SendWithRetry(context.Background(), http.DefaultClient, "", "tok-global", map[string]interface{}{}, opts)
+	if err != nil || string(body) != `{"ok":true}` {
+		t.Fatalf("expected failover success, got %q, %v", body, err)
+	}
+	if slept != 0 {
+		t.Fatalf("failover must not back off, slept %d times", slept)
+	}
+	if gotAuth.Load() != "Bearer tok-b" {
+		t.Fatalf("endpoint token not used: %v", gotAuth.Load())
+	}
+	if pool.Healthy(down.URL) || !pool.Healthy(up.URL) {
+		t.Fatal("health not tracked")
+	}
+
+	// The failed endpoint is skipped during its cooldown.
+	if _, err := SendWithRetry(context.Background(), http.DefaultClient, "", "", map[string]interface{}{}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if downHits != 1 || upHits != 2 {
+		t.Fatalf("hits down=%d up=%d", downHits, upHits)
+	}
+}
+
+func TestPoolCooldownAndThreshold(t *testing.T) {
+	now := time.Unix(0, 0)
+	pool, err := NewEndpointPool([]Endpoint{{URL: "http://a"}, {URL: "http://b", Priority: 1}},
+		PoolOptions{FailureThreshold: 2, Cooldown: 10 * time.Second, Now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.ReportFailure("http://a")
+	if got := pool.pick(nil).URL; got != "http://a" {
+		t.Fatalf("one failure below threshold must keep a, got %s", got)
+	}
+	pool.ReportFailure("http://a")
+	if got := pool.pick(nil).URL; got != "http://b" {
+		t.Fatalf("expected b while a cools down, got %s", got)
+	}
+	now = now.Add(11 * time.Second)
+	if got := pool.pick(nil).URL; got != "http://a" {
+		t.Fatalf("expected a after cooldown, got %s", got)
+	}
+
+	// With every endpoint down the one that recovers first is used.
+	pool.ReportFailure("http://b")
+	pool.ReportFailure("http://b")
+	now = now.Add(time.Second)
+	pool.ReportFailure("http://a")
+	if got := pool.pick(nil).URL; got != "http://b" {
+		t.Fatalf("expected earliest recovering endpoint b, got %s", got)
+	}
+}
+
+func TestPoolStrategies(t *testing.T) {
+	eps := []Endpoint{{URL: "http://a"}, {URL: "http://b"}, {URL: "http://c"}}
+	rr, _ := NewEndpointPool(eps, PoolOptions{Strategy: "round-robin"})
+	var seq string
+	for i := 0; i < 4; i++ {
+		seq += rr.pick(nil).URL[len("http://"):]
+	}
+	if seq != "abca" {
+		t.Fatalf("round-robin order %q", seq)
+	}
+
+	ll, _ := NewEndpointPool(eps, PoolOptions{Strategy: "least-latency"})
+	ll.ReportSuccess("http://a", 300*time.Millisecond)
+	ll.ReportSuccess("http://b", 100*time.Millisecond)
+	if got := ll.pick(nil).URL; got != "http://c" {
+		t.Fatalf("unmeasured endpoint must be tried first, got %s", got)
+	}
+	ll.ReportSuccess("http://c", 200*time.Millisecond)
+	if got := ll.pick(nil).URL; got != "http://b" {
+		t.Fatalf("expected fastest endpoint b, got %s", got)
+	}
+	if got := ll.pick(map[string]bool{"http://b": true}).URL; got != "http://c" {
+		t.Fatalf("expected fastest untried endpoint c, got %s", got)
+	}
+
+	if _, err := NewEndpointPool(eps, PoolOptions{Strategy: "random"}); err == nil {
+		t.Fatal("expected unknown strategy error")
+	}
+	if _, err := NewEndpointPool([]Endpoint{{URL: "gw-a:8080"}}, PoolOptions{}); err == nil {
+		t.Fatal("expected invalid URL error")
+	}
+}
+
+func TestPoolProbeRestoresHealth(t *testing.T) {
+	var healthy int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/healthz" {
+			t.Errorf("unexpected probe %s %s", r.Method, r.URL.Path)
+		}
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	pool, _ := NewEndpointPool([]Endpoint{{URL: srv.URL + "/v1/chat", HealthCheckURL: srv.URL + "/healthz"}}, PoolOptions{Cooldown: time.Hour})
+	pool.Probe(context.Background(), srv.Client(), time.Second)
+	if pool.Healthy(srv.URL + "/v1/chat") {
+		t.Fatal("503 probe must mark the endpoint down")
+	}
+	atomic.StoreInt32(&healthy, 1)
+	pool.Probe(context.Background(), srv.Client(), time.Second)
+	if !pool.Healthy(srv.URL + "/v1/chat") {
+		t.Fatal("successful probe must restore the endpoint")
+	}
+}
+
+func TestPoolProbeNeeds2xxWithoutHealthCheckURL(t *testing.T) {
+	var status int32 = http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer srv.Close()
+
+	pool, _ := NewEndpointPool([]Endpoint{{URL: srv.URL + "/v1/chat"}}, PoolOptions{Cooldown: time.Hour})
+	pool.Probe(context.Background(), srv.Client(), time.Second)
+	if pool.Healthy(srv.URL + "/v1/chat") {
+		t.Fatal("503 probe must mark the endpoint down")
+	}
+	atomic.StoreInt32(&status, http.StatusNotFound)
+	pool.Probe(context.Background(), srv.Client(), time.Second)
+	if pool.Healthy(srv.URL + "/v1/chat") {
+		t.Fatal("a 404 from the endpoint URL must not restore it")
+	}
+	atomic.StoreInt32(&status, http.StatusOK)
+	pool.Probe(context.Background(), srv.Client(), time.Second)
+	if !pool.Healthy(srv.URL + "/v1/chat") {
+		t.Fatal("a 200 probe must restore the endpoint")
+	}
+}
+
+func TestPoolProbeKeepsRequestCooldown(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	now := time.Now()
+	ep := srv.URL + "/v1/chat"
+	pool, _ := NewEndpointPool([]Endpoint{{URL: ep, HealthCheckURL: srv.URL + "/healthz"}},
+		PoolOptions{Cooldown: 10 * time.Second, Now: func() time.Time { return now }})
+	pool.ReportFailure(ep)
+	pool.Probe(context.Background(), srv.Client(), time.Second)
+	if pool.Healthy(ep) {
+		t.Fatal("a probe must not end the cooldown of a request failure")
+	}
+	now = now.Add(11 * time.Second)
+	if !pool.Healthy(ep) {
+		t.Fatal("expected the endpoint back after the cooldown")
+	}
+}
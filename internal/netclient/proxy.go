@@ -0,0 +1,116 @@
+package netclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxySettings selects the proxy of a request. An empty Proxy uses the
+// HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables, "direct" disables
+// proxying, and anything else is a proxy URL (http, https, socks5 or
+// socks5h, optionally with user:password). NoProxy is a comma separated
+// list of hosts, domains or CIDRs that bypass the proxy.
+type ProxySettings struct {
+	Proxy   string
+	NoProxy string
+}
+
+// ProxyDirect disables any proxy, including the environment.
+const ProxyDirect = "direct"
+
+type proxyCtxKey struct{}
+
+// WithProxy overrides the transport's proxy settings for requests made with ctx.
+func WithProxy(ctx context.Context, s ProxySettings) context.Context {
+	return context.WithValue(ctx, proxyCtxKey{}, s)
+}
+
+// ValidateProxy reports whether s can be turned into a proxy function.
+func ValidateProxy(s ProxySettings) error {
+	_, err := parseProxyURL(s.Proxy)
+	return err
+}
+
+func parseProxyURL(raw string) (*url.URL, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || strings.EqualFold(raw, ProxyDirect) || strings.EqualFold(raw, "none") {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", raw)
+	}
+	return u, nil
+}
+
+// proxyResolver implements http.Transport.Proxy for a default ProxySettings
+// plus per-request overrides, caching one resolved function per setting.
+type proxyResolver struct {
+	def   ProxySettings
+	mu    sync.Mutex
+	cache map[ProxySettings]func(*url.URL) (*url.URL, error)
+}
+
+func newProxyResolver(def ProxySettings) *proxyResolver {
+	return &proxyResolver{def: def, cache: map[ProxySettings]func(*url.URL) (*url.URL, error){}}
+}
+
+func (r *proxyResolver) Proxy(req *http.Request) (*url.URL, error) {
+	s := r.def
+	if v, ok := req.Context().Value(proxyCtxKey{}).(ProxySettings); ok {
+		s = v
+	}
+	fn, err := r.lookup(s)
+	if err != nil {
+		return nil, err
+	}
+	return fn(req.URL)
+}
+
+func (r *proxyResolver) lookup(s ProxySettings) (func(*url.URL) (*url.URL, error), error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if fn, ok := r.cache[s]; ok {
+		return fn, nil
+	}
+	fn, err := proxyFunc(s)
+	if err != nil {
+		return nil, err
+	}
+	r.cache[s] = fn
+	return fn, nil
+}
+
+func proxyFunc(s ProxySettings) (func(*url.URL) (*url.URL, error), error) {
+	raw := strings.TrimSpace(s.Proxy)
+	if strings.EqualFold(raw, ProxyDirect) || strings.EqualFold(raw, "none") {
+		return func(*url.URL) (*url.URL, error) { return nil, nil }, nil
+	}
+	if raw == "" {
+		cfg := httpproxy.FromEnvironment()
+		if strings.TrimSpace(s.NoProxy) != "" {
+			cfg.NoProxy = s.NoProxy
+		}
+		return cfg.ProxyFunc(), nil
+	}
+	u, err := parseProxyURL(raw)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &httpproxy.Config{HTTPProxy: u.String(), HTTPSProxy: u.String(), NoProxy: s.NoProxy}
+	return cfg.ProxyFunc(), nil
+}
@@ -0,0 +1,225 @@
+package netclient
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"stp/internal/config"
+)
+
+func TestHTTPProxyWithAuth(t *testing.T) {
+	var gotURL, gotAuth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURL, gotAuth = r.URL.String(), r.Header.Get("Proxy-Authorization")
+		_, _ = io.WriteString(w, `{"text":"via proxy"}`)
+	}))
+	defer proxy.Close()
+
+	cfg := config.Default()
+	cfg.EnableHTTP2 = false
+	cfg.Proxy = "http://alice:secret@" + proxy.Listener.Addr().String()
+	cli, tr, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.CloseIdleConnections()
+
+	body, err := SendWithRetry(context.Background(), cli, "http://llm.example/v1/chat", "", map[string]interface{}{}, RetryOptions{MaxRetry: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"text":"via proxy"}` || gotURL != "http://llm.example/v1/chat" {
+		t.Fatalf("request did not go through proxy: url=%s body=%s", gotURL, body)
+	}
+	if gotAuth != "Basic "+base64.StdEncoding.EncodeToString([]byte("alice:secret")) {
+		t.Fatalf("unexpected proxy auth: %q", gotAuth)
+	}
+}
+
+func TestSOCKS5ProxyAndPerRequestOverride(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"text":"direct"}`)
+	}))
+	defer target.Close()
+	socks := startSOCKS5(t, "bob", "pw", target.Listener.Addr().String())
+
+	cfg := config.Default()
+	cfg.EnableHTTP2 = false
+	cfg.Proxy = ProxyDirect
+	cli, tr, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.CloseIdleConnections()
+
+	// Loopback targets are never proxied, so use a name only the stand-in knows.
+	endpoint := "http://llm.test/v1"
+	ctx := WithProxy(context.Background(), ProxySettings{Proxy: "socks5h://bob:pw@" + socks.addr})
+	body, err := SendWithRetry(ctx, cli, endpoint, "", map[string]interface{}{}, RetryOptions{MaxRetry: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"text":"direct"}` || socks.connects() != 1 || socks.host() != "llm.test:80" {
+		t.Fatalf("expected one SOCKS5 tunnel to llm.test, got %d %q (body=%s)", socks.connects(), socks.host(), body)
+	}
+
+	if _, err := SendWithRetry(context.Background(), cli, target.URL, "", map[string]interface{}{}, RetryOptions{MaxRetry: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if socks.connects() != 1 {
+		t.Fatalf("global direct setting must not use the proxy")
+	}
+}
+
+func TestProxyFuncNoProxyAndValidation(t *testing.T) {
+	fn, err := proxyFunc(ProxySettings{Proxy: "http://proxy.corp:3128", NoProxy: "gateway.internal,10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"https://api.openai.com/v1":       true,
+		"https://notgateway.internal/v1":  true,
+		"https://gateway.internal/v1":     false,
+		"https://llm.gateway.internal/v1": false,
+		"http://10.1.2.3/v1":              false,
+	}
+	for raw, proxied := range cases {
+		u, _ := url.Parse(raw)
+		got, err := fn(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (got != nil) != proxied {
+			t.Fatalf("%s: proxied=%v, want %v", raw, got != nil, proxied)
+		}
+	}
+	for _, bad := range []string{"ftp://proxy:21", "http://", "://x"} {
+		if err := ValidateProxy(ProxySettings{Proxy: bad}); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+type socks5Server struct {
+	addr     string
+	upstream string
+	mu       chan struct{}
+	n        int
+	lastHost string
+}
+
+func (s *socks5Server) connects() int {
+	s.mu <- struct{}{}
+	defer func() { <-s.mu }()
+	return s.n
+}
+
+func (s *socks5Server) host() string {
+	s.mu <- struct{}{}
+	defer func() { <-s.mu }()
+	return s.lastHost
+}
+
+// startSOCKS5 runs a minimal RFC 1928/1929 server supporting CONNECT with
+// username/password authentication. Every tunnel is connected to upstream.
+func startSOCKS5(t *testing.T, user, pass, upstream string) *socks5Server {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	s := &socks5Server{addr: ln.Addr().String(), upstream: upstream, mu: make(chan struct{}, 1)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c, user, pass)
+		}
+	}()
+	return s
+}
+
+func (s *socks5Server) serve(c net.Conn, user, pass string) {
+	defer c.Close()
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(c, buf[:2]); err != nil || buf[0] != 5 {
+		return
+	}
+	if _, err := io.ReadFull(c, buf[:buf[1]]); err != nil {
+		return
+	}
+	_, _ = c.Write([]byte{5, 2})
+	// username/password sub-negotiation
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return
+	}
+	u := make([]byte, buf[1])
+	if _, err := io.ReadFull(c, u); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(c, buf[:1]); err != nil {
+		return
+	}
+	p := make([]byte, buf[0])
+	if _, err := io.ReadFull(c, p); err != nil {
+		return
+	}
+	if string(u) != user || string(p) != pass {
+		_, _ = c.Write([]byte{1, 1})
+		return
+	}
+	_, _ = c.Write([]byte{1, 0})
+
+	if _, err := io.ReadFull(c, buf[:4]); err != nil || buf[1] != 1 {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		if _, err := io.ReadFull(c, buf[:4]); err != nil {
+			return
+		}
+		host = net.IP(buf[:4]).String()
+	case 3:
+		if _, err := io.ReadFull(c, buf[:1]); err != nil {
+			return
+		}
+		n := int(buf[0])
+		if _, err := io.ReadFull(c, buf[:n]); err != nil {
+			return
+		}
+		host = string(buf[:n])
+	default:
+		return
+	}
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return
+	}
+	port := binary.BigEndian.Uint16(buf[:2])
+	up, err := net.Dial("tcp", s.upstream)
+	if err != nil {
+		_, _ = c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer up.Close()
+	s.mu <- struct{}{}
+	s.n++
+	s.lastHost = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	<-s.mu
+	_, _ = c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	done := make(chan struct{}, 2)
+	go func() { _, _ = io.Copy(up, c); done <- struct{}{} }()
+	go func() { _, _ = io.Copy(c, up); done <- struct{}{} }()
+	<-done
+}
@@ -0,0 +1,367 @@
+package netclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"stp/internal/config"
+)
+
+// RateLimiter queues requests locally so that one client stays within the
+// request and token budget of an endpoint and API key. Each endpoint host
+// and key pair has a token bucket for requests per minute and one for
+// (estimated) tokens per minute. With UseHeaders it also honors the
+// x-ratelimit-remaining-* / x-ratelimit-reset-* headers of the provider and
+// holds requests back once the reported budget is used up, before the
+// provider starts answering 429. It is safe for concurrent use.
+type RateLimiter struct {
+	// RequestsPerMinute and TokensPerMinute are the bucket sizes; zero
+	// disables the bucket.
+	RequestsPerMinute float64
+	TokensPerMinute   float64
+	UseHeaders        bool
+
+	// Now, Sleep and Logf are hooks; nil means time.Now, sleepWithContext
+	// and no logging.
+	Now   func() time.Time
+	Sleep func(context.Context, time.Duration) error
+	Logf  func(format string, args ...interface{})
+
+	mu    sync.Mutex
+	state map[string]*limitState
+}
+
+type limitState struct {
+	requests float64 // available bucket content
+	tokens   float64
+	last     time.Time
+	waiting  int
+
+	// Budget reported by the provider; -1 means unknown.
+	remainingRequests int
+	remainingTokens   int
+	requestsResetAt   time.Time
+	tokensResetAt     time.Time
+}
+
+// RateLimitState is a snapshot of one endpoint/key pair for logs and status
+// output. Key never contains the API key itself.
+type RateLimitState struct {
+	Key               string
+	Requests          float64
+	Tokens            float64
+	RemainingRequests int
+	RemainingTokens   int
+	ResumeAt          time.Time
+	Waiting           int
+}
+
+// NewRateLimiter builds the limiter described by cfg, or returns nil when
+// rate limiting is disabled.
+func NewRateLimiter(cfg config.Config) *RateLimiter {
+	if cfg.RateLimitRPM <= 0 && cfg.RateLimitTPM <= 0 && !cfg.RateLimitHeaders {
+		return nil
+	}
+	return &RateLimiter{
+		RequestsPerMinute: cfg.RateLimitRPM,
+		TokensPerMinute:   cfg.RateLimitTPM,
+		UseHeaders:        cfg.RateLimitHeaders,
+	}
+}
+
+func (l *RateLimiter) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+func (l *RateLimiter) logf(format string, args ...interface{}) {
+	if l.Logf != nil {
+		l.Logf(format, args...)
+	}
+}
+
+// limitKey identifies an endpoint host and API key without revealing the key.
+func limitKey(endpoint, token string) string {
+	host := endpointHost(endpoint)
+	if token == "" {
+		return host
+	}
+	sum := sha256.Sum256([]byte(token))
+	return host + " key#" + hex.EncodeToString(sum[:3])
+}
+
+// endpointHost returns the host of endpoint, which unlike the full URL
+// never carries a query string credential.
+func endpointHost(endpoint string) string {
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return endpoint
+}
+
+// get returns the state of key with its buckets refilled up to now.
+// l.mu must be held.
+func (l *RateLimiter) get(key string, now time.Time) *limitState {
+	if l.state == nil {
+		l.state = map[string]*limitState{}
+	}
+	s, ok := l.state[key]
+	if !ok {
+		s = &limitState{
+			requests:          l.RequestsPerMinute,
+			tokens:            l.TokensPerMinute,
+			last:              now,
+			remainingRequests: -1,
+			remainingTokens:   -1,
+		}
+		l.state[key] = s
+		return s
+	}
+	if elapsed := now.Sub(s.last).Minutes(); elapsed > 0 {
+		s.requests = minFloat(l.RequestsPerMinute, s.requests+elapsed*l.RequestsPerMinute)
+		s.tokens = minFloat(l.TokensPerMinute, s.tokens+elapsed*l.TokensPerMinute)
+		s.last = now
+	}
+	if !s.requestsResetAt.IsZero() && !now.Before(s.requestsResetAt) {
+		s.remainingRequests, s.requestsResetAt = -1, time.Time{}
+	}
+	if !s.tokensResetAt.IsZero() && !now.Before(s.tokensResetAt) {
+		s.remainingTokens, s.tokensResetAt = -1, time.Time{}
+	}
+	return s
+}
+
+// Wait reserves one request of about tokens tokens for endpoint and token
+// and blocks until the reservation is due. Reservations are served in
+// order; a canceled wait gives its bucket reservation back and returns
+// ctx's error.
+func (l *RateLimiter) Wait(ctx context.Context, endpoint, token string, tokens int) error {
+	key := limitKey(endpoint, token)
+	l.mu.Lock()
+	now := l.now()
+	s := l.get(key, now)
+	var wait time.Duration
+	if l.RequestsPerMinute > 0 {
+		s.requests--
+		if s.requests < 0 {
+			wait = maxDuration(wait, minutes(-s.requests/l.RequestsPerMinute))
+		}
+	}
+	cost := float64(tokens)
+	if l.TokensPerMinute > 0 {
+		// A single request larger than the bucket only has to wait for a full bucket.
+		cost = minFloat(cost, l.TokensPerMinute)
+		s.tokens -= cost
+		if s.tokens < 0 {
+			wait = maxDuration(wait, minutes(-s.tokens/l.TokensPerMinute))
+		}
+	}
+	if l.UseHeaders {
+		if s.remainingRequests == 0 {
+			wait = maxDuration(wait, s.requestsResetAt.Sub(now))
+		}
+		if s.remainingTokens >= 0 && s.remainingTokens < tokens {
+			wait = maxDuration(wait, s.tokensResetAt.Sub(now))
+		}
+		if s.remainingRequests > 0 {
+			s.remainingRequests--
+		}
+		if s.remainingTokens >= tokens {
+			s.remainingTokens -= tokens
+		}
+	}
+	if wait <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	s.waiting++
+	snap := l.snapshot(key, s, now.Add(wait))
+	l.mu.Unlock()
+
+	l.logf("[ratelimit] %s: waiting %v (%s)", key, wait.Round(time.Millisecond), snap)
+	sleep := l.Sleep
+	if sleep == nil {
+		sleep = sleepWithContext
+	}
+	err := sleep(ctx, wait)
+
+	l.mu.Lock()
+	s.waiting--
+	if err != nil {
+		if l.RequestsPerMinute > 0 {
+			s.requests++
+		}
+		if l.TokensPerMinute > 0 {
+			s.tokens += cost
+		}
+	}
+	l.mu.Unlock()
+	return err
+}
+
+// Observe records the budget reported in the rate limit headers of a
+// response for endpoint and token.
+func (l *RateLimiter) Observe(endpoint, token string, h http.Header) {
+	if !l.UseHeaders || h == nil {
+		return
+	}
+	key := limitKey(endpoint, token)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	s := l.get(key, now)
+	if n, reset, ok := rateLimitHeader(h, "requests", now); ok {
+		s.remainingRequests, s.requestsResetAt = n, reset
+	}
+	if n, reset, ok := rateLimitHeader(h, "tokens", now); ok {
+		s.remainingTokens, s.tokensResetAt = n, reset
+	}
+}
+
+// rateLimitHeader reads x-ratelimit-remaining-<kind> and
+// x-ratelimit-reset-<kind>; for requests the unsuffixed form is accepted
+// too. A remaining count without a reset time is ignored since it would
+// block forever.
+func rateLimitHeader(h http.Header, kind string, now time.Time) (int, time.Time, bool) {
+	remaining := h.Get("X-Ratelimit-Remaining-" + kind)
+	reset := h.Get("X-Ratelimit-Reset-" + kind)
+	if remaining == "" && kind == "requests" {
+		remaining, reset = h.Get("X-Ratelimit-Remaining"), h.Get("X-Ratelimit-Reset")
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(remaining))
+	if err != nil || n < 0 {
+		return 0, time.Time{}, false
+	}
+	at, ok := parseRateLimitReset(reset, now)
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	return n, at, true
+}
+
+// parseRateLimitReset accepts a Go style duration ("1s", "6m0s", "20ms"),
+// seconds, or a Unix timestamp in seconds.
+func parseRateLimitReset(v string, now time.Time) (time.Time, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, false
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		if f < 0 {
+			return time.Time{}, false
+		}
+		if f > 1e9 {
+			return time.Unix(int64(f), 0), true
+		}
+		return now.Add(time.Duration(f * float64(time.Second))), true
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return time.Time{}, false
+	}
+	return now.Add(d), true
+}
+
+// Snapshot returns the state of every endpoint/key pair seen so far,
+// sorted by key.
+func (l *RateLimiter) Snapshot() []RateLimitState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	out := make([]RateLimitState, 0, len(l.state))
+	for key := range l.state {
+		s := l.get(key, now)
+		st := RateLimitState{
+			Key:               key,
+			Requests:          s.requests,
+			Tokens:            s.tokens,
+			RemainingRequests: s.remainingRequests,
+			RemainingTokens:   s.remainingTokens,
+			Waiting:           s.waiting,
+		}
+		if s.remainingRequests == 0 {
+			st.ResumeAt = s.requestsResetAt
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+func (l *RateLimiter) snapshot(key string, s *limitState, resumeAt time.Time) string {
+	var parts []string
+	if l.RequestsPerMinute > 0 {
+		parts = append(parts, fmt.Sprintf("requests %.1f/%g per min", s.requests, l.RequestsPerMinute))
+	}
+	if l.TokensPerMinute > 0 {
+		parts = append(parts, fmt.Sprintf("tokens %.0f/%g per min", s.tokens, l.TokensPerMinute))
+	}
+	if s.remainingRequests >= 0 {
+		parts = append(parts, fmt.Sprintf("server remaining requests %d", s.remainingRequests))
+	}
+	if s.remainingTokens >= 0 {
+		parts = append(parts, fmt.Sprintf("server remaining tokens %d", s.remainingTokens))
+	}
+	parts = append(parts, fmt.Sprintf("queued %d", s.waiting), "resume at "+resumeAt.Format("15:04:05.000"))
+	return strings.Join(parts, ", ")
+}
+
+// String formats s like the limiter's log lines.
+func (s RateLimitState) String() string {
+	out := fmt.Sprintf("%s: requests=%.1f tokens=%.0f remaining_requests=%d remaining_tokens=%d queued=%d",
+		s.Key, s.Requests, s.Tokens, s.RemainingRequests, s.RemainingTokens, s.Waiting)
+	if !s.ResumeAt.IsZero() {
+		out += " resume_at=" + s.ResumeAt.Format("15:04:05")
+	}
+	return out
+}
+
+// estimateTokens guesses the token cost of a request: about four bytes of
+// JSON per prompt token plus the requested completion limit.
+func estimateTokens(payload map[string]interface{}, data []byte) int {
+	n := len(data) / 4
+	for _, k := range []string{"max_tokens", "max_completion_tokens", "max_output_tokens"} {
+		if v, ok := payload[k].(float64); ok {
+			return n + int(v)
+		}
+		if v, ok := payload[k].(int); ok {
+			return n + v
+		}
+	}
+	if ic, ok := payload["inferenceConfig"].(map[string]interface{}); ok {
+		switch v := ic["maxTokens"].(type) {
+		case float64:
+			return n + int(v)
+		case int:
+			return n + v
+		}
+	}
+	return n
+}
+
+func minutes(m float64) time.Duration { return time.Duration(m * float64(time.Minute)) }
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,136 @@
+package netclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock advances when the limiter sleeps.
+type fakeClock struct {
+	now   time.Time
+	slept []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.slept = append(c.slept, d)
+	c.now = c.now.Add(d)
+	return ctx.Err()
+}
+
+func TestRateLimiterBuckets(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	l := &RateLimiter{RequestsPerMinute: 2, TokensPerMinute: 1000, Now: clock.Now, Sleep: clock.Sleep}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := l.Wait(ctx, "https://api.example/v1", "k1", 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(clock.slept) != 0 {
+		t.Fatalf("burst within the bucket must not wait: %v", clock.slept)
+	}
+	if err := l.Wait(ctx, "https://api.example/v1", "k1", 100); err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.slept) != 1 || clock.slept[0] != 30*time.Second {
+		t.Fatalf("third request should wait 30s, slept %v", clock.slept)
+	}
+
+	// Another key has its own buckets.
+	if err := l.Wait(ctx, "https://api.example/v1", "k2", 100); err != nil || len(clock.slept) != 1 {
+		t.Fatalf("separate key must not wait: %v %v", err, clock.slept)
+	}
+
+	// The token bucket: 600 of 1000 are left, so 900 tokens need 300 more.
+	clock.slept = nil
+	if err := l.Wait(ctx, "https://other.example/v1", "k1", 400); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Wait(ctx, "https://other.example/v1", "k1", 900); err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.slept) != 1 || clock.slept[0] != 18*time.Second {
+		t.Fatalf("expected an 18s token wait, slept %v", clock.slept)
+	}
+
+	for _, st := range l.Snapshot() {
+		if strings.Contains(st.String(), "k1") || strings.Contains(st.Key, "k2") {
+			t.Fatalf("snapshot leaks the key: %s", st)
+		}
+	}
+}
+
+func TestRateLimiterCanceledWaitRefunds(t *testing.T) {
+	l := &RateLimiter{RequestsPerMinute: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := l.Wait(ctx, "https://api.example", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := l.Wait(ctx, "https://api.example", "", 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled wait, got %v", err)
+	}
+	if st := l.Snapshot(); len(st) != 1 || st[0].Requests < -0.01 || st[0].Waiting != 0 {
+		t.Fatalf("canceled reservation not returned: %+v", st)
+	}
+}
+
+func TestRateLimitHeadersSlowDown(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		w.Header().Set("x-ratelimit-remaining-requests", fmt.Sprint(2-n))
+		w.Header().Set("x-ratelimit-reset-requests", "6m0s")
+		w.Header().Set("x-ratelimit-remaining-tokens", "90000")
+		w.Header().Set("x-ratelimit-reset-tokens", "20ms")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	var logs []string
+	l := &RateLimiter{UseHeaders: true, Now: clock.Now, Sleep: clock.Sleep, Logf: func(f string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(f, args...))
+	}}
+	opts := RetryOptions{MaxRetry: 1, Limiter: l}
+	for i := 0; i < 3; i++ {
+		if _, err := SendWithRetry(context.Background(), srv.Client(), srv.URL, "secret", map[string]interface{}{}, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(clock.slept) != 1 || clock.slept[0] != 6*time.Minute {
+		t.Fatalf("expected one wait for the reset, slept %v", clock.slept)
+	}
+	if len(logs) != 1 || !strings.Contains(logs[0], "server remaining requests 0") || strings.Contains(logs[0], "secret") {
+		t.Fatalf("unexpected log %q", logs)
+	}
+}
+
+func TestParseRateLimitReset(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cases := map[string]time.Duration{
+		"1s":         time.Second,
+		"6m0s":       6 * time.Minute,
+		"20ms":       20 * time.Millisecond,
+		"1.5":        1500 * time.Millisecond,
+		"1700000060": time.Minute,
+	}
+	for in, want := range cases {
+		got, ok := parseRateLimitReset(in, now)
+		if !ok || got.Sub(now) != want {
+			t.Errorf("%q: got %v, %v", in, got.Sub(now), ok)
+		}
+	}
+	if _, ok := parseRateLimitReset("soon", now); ok {
+		t.Error("expected invalid reset to be rejected")
+	}
+}
@@ -4,22 +4,72 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
+// DefaultUserAgent is sent when no UserAgent is configured.
+const DefaultUserAgent = "clip-hotkey-client/1.0"
+
 type RetryOptions struct {
 	MaxRetry  int
 	BaseDelay time.Duration
+	// AttemptTimeout bounds a single attempt including reading the body.
+	// Zero means no per-attempt limit.
+	AttemptTimeout time.Duration
+	// ConnectTimeout overrides the transport's dial timeout for this request.
+	ConnectTimeout time.Duration
+	// HeaderTimeout bounds the wait for the response headers (time to first byte).
+	HeaderTimeout time.Duration
+	// IdleTimeout bounds the gap between two chunks of the response body.
+	IdleTimeout time.Duration
+	// Policy classifies failures and shapes the backoff; nil means
+	// DefaultRetryPolicy.
+	Policy    *RetryPolicy
 	Debug     bool
 	Sleep     func(context.Context, time.Duration) error
 	UserAgent string
+	// Headers are extra request headers; they may replace Content-Type and
+	// User-Agent but not the auth header set by Auth.
+	Headers map[string]string
+	// Auth selects how token is sent (see AuthBearer and friends); AuthParam
+	// names the header or query parameter for the header and query schemes.
+	Auth      string
+	AuthParam string
+	// Signer, when set, signs the final request after all headers are set.
+	Signer RequestSigner
+	// TokenSource, when set, replaces the static token. A 401 invalidates
+	// the token and is retried once with a fresh one without counting as
+	// an attempt.
+	TokenSource TokenSource
+	// Pool, when set, supplies the URL (and optionally the token) of every
+	// attempt instead of endpoint, failing over to another endpoint after
+	// a transport error or retryable status.
+	Pool *EndpointPool
+	// MapURL rewrites the URL of each attempt, e.g. to fill in the model.
+	MapURL func(string) string
+	// Limiter, when set, delays each attempt to stay within the rate limits
+	// of its endpoint and key.
+	Limiter *RateLimiter
+	// Breaker, when set, fails the request at once while the circuit of
+	// the endpoint is open.
+	Breaker *CircuitBreaker
+	// Keys, when set, supplies the API key of each attempt in place of
+	// token unless the endpoint or TokenSource provides one. A key that is
+	// rejected or out of quota is benched and the attempt is repeated with
+	// another key without counting.
+	Keys *KeyRing
+	// ResolveToken, when set, maps the token of each attempt to the value
+	// actually sent, e.g. to decrypt a secret reference just in time.
+	ResolveToken func(string) (string, error)
 }
 
 func SendWithRetry(ctx context.Context, doer Doer, endpoint, token string, payload map[string]interface{}, opts RetryOptions) ([]byte, error) {
-	if endpoint == "" {
+	if endpoint == "" && opts.Pool == nil {
 		return nil, fmt.Errorf("API endpoint empty")
 	}
 	if opts.MaxRetry <= 0 {
@@ -32,59 +82,342 @@ func SendWithRetry(ctx context.Context, doer Doer, endpoint, token string, paylo
 		opts.Sleep = sleepWithContext
 	}
 	if opts.UserAgent == "" {
-		opts.UserAgent = "clip-hotkey-client/1.0"
+		opts.UserAgent = DefaultUserAgent
+	}
+	policy := opts.Policy
+	if policy == nil {
+		policy = DefaultRetryPolicy()
 	}
 
 	data, err := json.Marshal(payload)
 	if err != nil {
 		return nil, err
 	}
+	// A malformed endpoint will never succeed, so do not retry it.
+	if opts.Pool == nil {
+		if _, err := http.NewRequest(http.MethodPost, mapURL(opts, endpoint), nil); err != nil {
+			return nil, err
+		}
+	}
 
-	delay := opts.BaseDelay
+	estimate := estimateTokens(payload, data)
+	start := policy.now()
 	var lastErr error
-	for attempt := 1; attempt <= opts.MaxRetry; attempt++ {
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(data))
-		if err != nil {
-			return nil, err
+	refreshed := false
+	tried := map[string]bool{}
+	keysTried := map[int]bool{}
+	attempt := 1
+	for ; attempt <= opts.MaxRetry; attempt++ {
+		target, attemptToken := endpoint, token
+		keyIndex := -1
+		endpointToken := false
+		if opts.Pool != nil {
+			ep := opts.Pool.pick(tried)
+			target = ep.URL
+			if ep.Token != "" {
+				attemptToken = ep.Token
+				endpointToken = true
+			}
+			tried[target] = true
+		}
+		if opts.Keys != nil && opts.TokenSource == nil && !endpointToken {
+			keyIndex, attemptToken = opts.Keys.pick(keysTried)
+			keysTried[keyIndex] = true
+			// Only the index is logged, never the key.
+			opts.Keys.logf("[keys] attempt %d using key #%d", attempt, keyIndex+1)
+		}
+		if opts.Breaker != nil {
+			if retryAt, ok := opts.Breaker.Allow(target); !ok {
+				if opts.Pool != nil && opts.Pool.hasAlternative(tried) {
+					attempt--
+					continue
+				}
+				if opts.Debug {
+					fmt.Printf("[request] circuit open for %s, failing fast\n", endpointHost(target))
+				}
+				return nil, &CircuitOpenError{Endpoint: endpointHost(target), RetryAt: retryAt, Attempts: attempt - 1, Err: lastErr}
+			}
+		}
+		var res attemptResult
+		var err error
+		if opts.TokenSource != nil {
+			attemptToken, err = opts.TokenSource.Token(ctx)
+		}
+		if err == nil && opts.Limiter != nil {
+			if werr := opts.Limiter.Wait(ctx, target, attemptToken, estimate); werr != nil {
+				if opts.Breaker != nil {
+					opts.Breaker.Release(target)
+				}
+				return nil, ContextError(ctx, attempt-1)
+			}
+		}
+		sendToken := attemptToken
+		if err == nil && opts.ResolveToken != nil {
+			if sendToken, err = opts.ResolveToken(attemptToken); err != nil {
+				if opts.Breaker != nil {
+					opts.Breaker.Release(target)
+				}
+				return nil, fmt.Errorf("resolve token: %w", err)
+			}
+		}
+		sent := false
+		attemptStart := time.Now()
+		if err == nil {
+			sent = true
+			res, err = doAttempt(ctx, doer, mapURL(opts, target), sendToken, data, opts)
+			if err == nil && opts.Limiter != nil {
+				opts.Limiter.Observe(target, attemptToken, res.header)
+			}
+		}
+		if opts.Breaker != nil {
+			switch {
+			case !sent || ctx.Err() != nil:
+				opts.Breaker.Release(target)
+			case err != nil || res.status >= 500:
+				opts.Breaker.Failure(target)
+			default:
+				opts.Breaker.Success(target)
+			}
+		}
+		if err == nil && res.status >= 200 && res.status < 300 {
+			if opts.Pool != nil {
+				opts.Pool.ReportSuccess(target, time.Since(attemptStart))
+			}
+			return res.body, nil
 		}
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("User-Agent", opts.UserAgent)
-		if token != "" {
-			req.Header.Set("Authorization", "Bearer "+token)
+		if ctx.Err() != nil {
+			return nil, ContextError(ctx, attempt)
+		}
+		if opts.Pool != nil && sent && (err != nil || policy.retryableStatus(res.status)) {
+			opts.Pool.ReportFailure(target)
+		}
+		if err == nil && res.status == http.StatusUnauthorized && opts.TokenSource != nil && !refreshed {
+			if opts.Debug {
+				fmt.Printf("[request] 401 with cached token, refreshing\n")
+			}
+			opts.TokenSource.Invalidate(attemptToken)
+			refreshed = true
+			attempt--
+			continue
+		}
+		if err == nil && keyIndex >= 0 {
+			if reason := keyRejected(res.status, res.body); reason != "" {
+				retryAfter, _ := parseRetryAfter(res.header, policy.now())
+				opts.Keys.benchKey(keyIndex, reason, retryAfter)
+				if opts.Keys.hasAlternative(keysTried) {
+					if opts.Debug {
+						fmt.Printf("[request] key #%d rejected (%s); switching key\n", keyIndex+1, reason)
+					}
+					attempt--
+					continue
+				}
+			}
 		}
 
-		resp, err := doer.Do(req)
-		if err != nil {
-			if ctx.Err() != nil {
-				return nil, ctx.Err()
+		var retryAfter time.Duration
+		var hasRetryAfter bool
+		var tokenErr *StatusError
+		if errors.As(err, &tokenErr) {
+			// The token endpoint answered with an error status.
+			tokenErr.Attempts = attempt
+			lastErr = tokenErr
+			if !policy.retryableStatus(tokenErr.StatusCode) {
+				break
+			}
+		} else if err != nil {
+			lastErr = classifyError(err, attempt)
+			if !policy.retryableError(ctx, err) {
+				break
 			}
-			lastErr = err
 		} else {
-			body, readErr := io.ReadAll(resp.Body)
-			_ = resp.Body.Close()
-			if readErr != nil {
-				lastErr = readErr
-			} else if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-				return body, nil
-			} else {
-				lastErr = fmt.Errorf("status %d: %s", resp.StatusCode, string(body))
+			retryAfter, hasRetryAfter = parseRetryAfter(res.header, policy.now())
+			lastErr = &StatusError{
+				StatusCode: res.status,
+				Message:    ProviderMessage(res.body),
+				Body:       string(res.body),
+				RetryAfter: retryAfter,
+				Attempts:   attempt,
+			}
+			if !policy.retryableStatus(res.status) {
+				break
 			}
 		}
 
 		if attempt == opts.MaxRetry {
 			break
 		}
+		if opts.Breaker != nil && opts.Pool == nil {
+			// No point in backing off for an endpoint that just tripped.
+			if retryAt, open := opts.Breaker.openUntil(target); open {
+				return nil, &CircuitOpenError{Endpoint: endpointHost(target), RetryAt: retryAt, Attempts: attempt, Err: lastErr}
+			}
+		}
+		if opts.Pool != nil && opts.Pool.hasAlternative(tried) {
+			// Another endpoint is healthy: fail over without waiting.
+			if opts.Debug {
+				fmt.Printf("[request] attempt %d on %s failed: %v; failing over\n", attempt, target, lastErr)
+			}
+			continue
+		}
+		delay := policy.backoff(opts.BaseDelay, attempt)
+		if hasRetryAfter {
+			if policy.MaxDelay > 0 && retryAfter > policy.MaxDelay {
+				if opts.Debug {
+					fmt.Printf("[request] Retry-After %v exceeds max delay, giving up\n", retryAfter)
+				}
+				break
+			}
+			delay = retryAfter
+		}
+		if policy.Deadline > 0 && policy.now().Add(delay).Sub(start) >= policy.Deadline {
+			if opts.Debug {
+				fmt.Printf("[request] retry deadline %v reached\n", policy.Deadline)
+			}
+			break
+		}
+		if opts.Debug {
+			fmt.Printf("[request] attempt %d failed: %v; retrying in %v\n", attempt, lastErr, delay)
+		}
 		if err := opts.Sleep(ctx, delay); err != nil {
-			return nil, err
+			if ctx.Err() != nil {
+				return nil, ContextError(ctx, attempt)
+			}
+			return nil, &CanceledError{Attempts: attempt, Err: err}
 		}
-		delay *= 2
 	}
 	if lastErr == nil {
-		lastErr = fmt.Errorf("request failed")
+		lastErr = &TransportError{Attempts: attempt, Err: fmt.Errorf("request failed")}
 	}
 	return nil, lastErr
 }
 
+func mapURL(opts RetryOptions, u string) string {
+	if opts.MapURL != nil {
+		return opts.MapURL(u)
+	}
+	return u
+}
+
+type attemptResult struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// doAttempt performs one request. A non-2xx response is not an error here;
+// the caller classifies it with the retry policy.
+func doAttempt(ctx context.Context, doer Doer, endpoint, token string, data []byte, opts RetryOptions) (attemptResult, error) {
+	if opts.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.AttemptTimeout)
+		defer cancel()
+	}
+	if opts.ConnectTimeout > 0 {
+		ctx = context.WithValue(ctx, dialTimeoutKey{}, opts.ConnectTimeout)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(data))
+	if err != nil {
+		return attemptResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", opts.UserAgent)
+	for k, v := range opts.Headers {
+		if strings.EqualFold(k, "Host") {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	setAuth(req, opts.Auth, opts.AuthParam, token)
+	if opts.Signer != nil {
+		if err := opts.Signer.Sign(req, data); err != nil {
+			return attemptResult{}, err
+		}
+	}
+
+	var headerTimer *time.Timer
+	if opts.HeaderTimeout > 0 {
+		headerTimer = time.AfterFunc(opts.HeaderTimeout, func() {
+			cancel(&phaseTimeoutError{phase: "response header", limit: opts.HeaderTimeout})
+		})
+	}
+	resp, err := doer.Do(req)
+	if headerTimer != nil {
+		headerTimer.Stop()
+	}
+	if err != nil {
+		return attemptResult{}, attemptError(ctx, redactAuthQuery(err, opts.Auth, opts.AuthParam))
+	}
+
+	var body []byte
+	if opts.IdleTimeout > 0 {
+		r := &idleTimeoutReader{r: resp.Body, d: opts.IdleTimeout, cancel: cancel}
+		body, err = r.readAll()
+	} else {
+		body, err = io.ReadAll(resp.Body)
+	}
+	_ = resp.Body.Close()
+	if err != nil {
+		return attemptResult{}, attemptError(ctx, err)
+	}
+	return attemptResult{status: resp.StatusCode, header: resp.Header, body: body}, nil
+}
+
+// attemptError reports the reason a phase timer canceled the attempt
+// instead of the generic "context canceled".
+func attemptError(ctx context.Context, err error) error {
+	var pe *phaseTimeoutError
+	if cause := context.Cause(ctx); errors.As(cause, &pe) {
+		return pe
+	}
+	return err
+}
+
+// phaseTimeoutError is a net.Error timeout for one phase of an attempt.
+type phaseTimeoutError struct {
+	phase string
+	limit time.Duration
+}
+
+func (e *phaseTimeoutError) Error() string {
+	return fmt.Sprintf("%s timeout after %v", e.phase, e.limit)
+}
+
+func (e *phaseTimeoutError) Timeout() bool   { return true }
+func (e *phaseTimeoutError) Temporary() bool { return true }
+
+// idleTimeoutReader cancels the attempt when no data arrives for d.
+type idleTimeoutReader struct {
+	r      io.Reader
+	d      time.Duration
+	cancel context.CancelCauseFunc
+}
+
+func (r *idleTimeoutReader) readAll() ([]byte, error) {
+	timer := time.AfterFunc(r.d, func() {
+		r.cancel(&phaseTimeoutError{phase: "stream idle", limit: r.d})
+	})
+	defer timer.Stop()
+	var buf bytes.Buffer
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := r.r.Read(chunk)
+		if n > 0 {
+			timer.Reset(r.d)
+			buf.Write(chunk[:n])
+		}
+		if err == io.EOF {
+			return buf.Bytes(), nil
+		}
+		if err != nil {
+			return buf.Bytes(), err
+		}
+	}
+}
+
 func sleepWithContext(ctx context.Context, d time.Duration) error {
 	if d <= 0 {
 		select {
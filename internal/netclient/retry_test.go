@@ -2,6 +2,7 @@ package netclient
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -65,10 +66,183 @@ func TestSendWithRetryCanceledDuringBackoff(t *testing.T) {
 			return ctx.Err()
 		},
 	})
-	if err == nil {
-		t.Fatalf("expected cancel error")
+	var canceled *CanceledError
+	if !errors.As(err, &canceled) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected CanceledError, got %#v", err)
 	}
 	if ctx.Err() == nil {
 		t.Fatalf("ctx should be canceled")
 	}
 }
+
+func statusResponse(code int, header http.Header) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{StatusCode: code, Header: header, Body: io.NopCloser(strings.NewReader(`{}`))}
+}
+
+func TestSendWithRetryDoesNotRetryClientErrors(t *testing.T) {
+	d := &fakeDoer{fn: func(req *http.Request, attempt int) (*http.Response, error) {
+		return statusResponse(401, nil), nil
+	}}
+	_, err := SendWithRetry(context.Background(), d, "https://example", "", map[string]interface{}{}, RetryOptions{
+		MaxRetry: 3,
+		Sleep: func(ctx context.Context, d time.Duration) error {
+			t.Fatalf("401 must not be retried")
+			return nil
+		},
+	})
+	if err == nil || d.attempts != 1 {
+		t.Fatalf("expected a single failed attempt, attempts=%d err=%v", d.attempts, err)
+	}
+}
+
+func TestSendWithRetryHonorsRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	headers := []http.Header{
+		{"Retry-After": []string{"3"}},
+		{"Retry-After": []string{now.Add(7 * time.Second).Format(http.TimeFormat)}},
+	}
+	d := &fakeDoer{fn: func(req *http.Request, attempt int) (*http.Response, error) {
+		if attempt <= len(headers) {
+			return statusResponse(429, headers[attempt-1]), nil
+		}
+		return statusResponse(200, nil), nil
+	}}
+	var delays []time.Duration
+	policy := DefaultRetryPolicy()
+	policy.Now = func() time.Time { return now }
+	_, err := SendWithRetry(context.Background(), d, "https://example", "", map[string]interface{}{}, RetryOptions{
+		MaxRetry:  3,
+		BaseDelay: time.Millisecond,
+		Policy:    policy,
+		Sleep: func(ctx context.Context, d time.Duration) error {
+			delays = append(delays, d)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delays) != 2 || delays[0] != 3*time.Second || delays[1] != 7*time.Second {
+		t.Fatalf("unexpected delays: %v", delays)
+	}
+}
+
+func TestSendWithRetryJitterAndMaxDelay(t *testing.T) {
+	d := &fakeDoer{fn: func(req *http.Request, attempt int) (*http.Response, error) {
+		return statusResponse(503, nil), nil
+	}}
+	var delays []time.Duration
+	policy := DefaultRetryPolicy()
+	policy.MaxDelay = 3 * time.Second
+	policy.Rand = func() float64 { return 0.5 }
+	_, err := SendWithRetry(context.Background(), d, "https://example", "", map[string]interface{}{}, RetryOptions{
+		MaxRetry:  5,
+		BaseDelay: time.Second,
+		Policy:    policy,
+		Sleep: func(ctx context.Context, d time.Duration) error {
+			delays = append(delays, d)
+			return nil
+		},
+	})
+	if err == nil {
+		t.Fatalf("expected failure")
+	}
+	want := []time.Duration{500 * time.Millisecond, time.Second, 1500 * time.Millisecond, 1500 * time.Millisecond}
+	if fmt.Sprint(delays) != fmt.Sprint(want) {
+		t.Fatalf("unexpected delays: %v want %v", delays, want)
+	}
+}
+
+func TestBackoffUncappedDoesNotOverflow(t *testing.T) {
+	policy := DefaultRetryPolicy()
+	policy.MaxDelay = 0
+	policy.Jitter = false
+	prev := time.Duration(0)
+	for retry := 1; retry <= 200; retry++ {
+		d := policy.backoff(time.Second, retry)
+		if d < prev || d > maxBackoff {
+			t.Fatalf("retry %d: backoff %v after %v", retry, d, prev)
+		}
+		prev = d
+	}
+	if prev != maxBackoff {
+		t.Fatalf("expected the backoff to settle at %v, got %v", maxBackoff, prev)
+	}
+}
+
+func TestSendWithRetryDeadlineAndErrorClasses(t *testing.T) {
+	d := &fakeDoer{fn: func(req *http.Request, attempt int) (*http.Response, error) {
+		return nil, fmt.Errorf("connection refused")
+	}}
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	policy := DefaultRetryPolicy()
+	policy.Jitter = false
+	policy.Deadline = 2500 * time.Millisecond
+	policy.Now = func() time.Time { return now }
+	_, err := SendWithRetry(context.Background(), d, "https://example", "", map[string]interface{}{}, RetryOptions{
+		MaxRetry:  10,
+		BaseDelay: time.Second,
+		Policy:    policy,
+		Sleep: func(ctx context.Context, d time.Duration) error {
+			now = now.Add(d)
+			return nil
+		},
+	})
+	if err == nil || d.attempts != 2 {
+		t.Fatalf("deadline should stop after 2 attempts, got %d (err=%v)", d.attempts, err)
+	}
+
+	d.attempts = 0
+	policy = DefaultRetryPolicy()
+	policy.RetryNetworkErrors = false
+	_, _ = SendWithRetry(context.Background(), d, "https://example", "", map[string]interface{}{}, RetryOptions{MaxRetry: 3, Policy: policy})
+	if d.attempts != 1 {
+		t.Fatalf("network errors should not be retried, attempts=%d", d.attempts)
+	}
+}
+
+func TestSendWithRetryTypedErrors(t *testing.T) {
+	d := &fakeDoer{fn: func(req *http.Request, attempt int) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 429,
+			Header:     http.Header{"Retry-After": []string{"1"}},
+			Body:       io.NopCloser(strings.NewReader(`{"error":{"message":"quota exceeded","type":"insufficient_quota"}}`)),
+		}, nil
+	}}
+	_, err := SendWithRetry(context.Background(), d, "https://example", "", map[string]interface{}{}, RetryOptions{
+		MaxRetry: 2,
+		Sleep:    func(ctx context.Context, d time.Duration) error { return nil },
+	})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected StatusError, got %#v", err)
+	}
+	if statusErr.StatusCode != 429 || statusErr.Message != "quota exceeded" || statusErr.Attempts != 2 || statusErr.RetryAfter != time.Second {
+		t.Fatalf("unexpected status error: %#v", statusErr)
+	}
+
+	d = &fakeDoer{fn: func(req *http.Request, attempt int) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}}
+	_, err = SendWithRetry(context.Background(), d, "https://example", "", map[string]interface{}{}, RetryOptions{
+		MaxRetry:       1,
+		AttemptTimeout: time.Millisecond,
+	})
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Attempts != 1 {
+		t.Fatalf("expected TimeoutError, got %#v", err)
+	}
+
+	d = &fakeDoer{fn: func(req *http.Request, attempt int) (*http.Response, error) {
+		return nil, fmt.Errorf("connection refused")
+	}}
+	_, err = SendWithRetry(context.Background(), d, "https://example", "", map[string]interface{}{}, RetryOptions{MaxRetry: 1})
+	var transportErr *TransportError
+	if !errors.As(err, &transportErr) {
+		t.Fatalf("expected TransportError, got %#v", err)
+	}
+}
@@ -0,0 +1,219 @@
+package netclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"stp/internal/config"
+)
+
+// RequestSigner signs the final request right before it is sent; body is
+// the exact payload of the request.
+type RequestSigner interface {
+	Sign(req *http.Request, body []byte) error
+}
+
+// AuthSigV4 signs requests with AWS Signature Version 4 instead of sending
+// the token (see SigV4Signer).
+const AuthSigV4 = "sigv4"
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	defaultService  = "bedrock"
+)
+
+// sigV4Ignored are headers that proxies or the transport may rewrite, so
+// they are never signed.
+var sigV4Ignored = map[string]bool{
+	"authorization":   true,
+	"user-agent":      true,
+	"x-amzn-trace-id": true,
+	"expect":          true,
+}
+
+// SigV4Signer implements AWS Signature Version 4 for header based signing.
+type SigV4Signer struct {
+	Region          string
+	Service         string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Now             func() time.Time
+}
+
+// NewSigV4Signer builds a signer from cfg, filling unset credentials and the
+// region from the standard AWS_* environment variables.
+func NewSigV4Signer(cfg config.SigV4Config) (*SigV4Signer, error) {
+	s := &SigV4Signer{
+		Region:          firstNonEmpty(cfg.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")),
+		Service:         firstNonEmpty(cfg.Service, defaultService),
+		AccessKeyID:     firstNonEmpty(cfg.AccessKeyID, os.Getenv("AWS_ACCESS_KEY_ID")),
+		SecretAccessKey: firstNonEmpty(cfg.SecretAccessKey, os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		SessionToken:    strings.TrimSpace(cfg.SessionToken),
+	}
+	// A session token only belongs to the key pair it was issued with.
+	if strings.TrimSpace(cfg.AccessKeyID) == "" && s.SessionToken == "" {
+		s.SessionToken = strings.TrimSpace(os.Getenv("AWS_SESSION_TOKEN"))
+	}
+	if s.Region == "" {
+		return nil, fmt.Errorf("SigV4 region empty (set SigV4.Region or AWS_REGION)")
+	}
+	if s.AccessKeyID == "" || s.SecretAccessKey == "" {
+		return nil, fmt.Errorf("SigV4 credentials missing (set SigV4.AccessKeyID/SecretAccessKey or AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY)")
+	}
+	return s, nil
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Sign adds X-Amz-Date, X-Amz-Security-Token (when set) and the
+// Authorization header to req. Every other header already on req, plus
+// Host, is signed.
+func (s *SigV4Signer) Sign(req *http.Request, body []byte) error {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	t := now().UTC()
+	amzDate := t.Format(sigV4TimeFormat)
+	date := amzDate[:8]
+
+	req.Header.Del("Authorization")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	canonicalHeaders, signedHeaders := sigV4Headers(req)
+	payloadHash := sha256Hex(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		sigV4URI(req),
+		sigV4Query(req),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.Region, s.Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+	signature := hex.EncodeToString(hmacSHA256(s.signingKey(date), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.AccessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+func (s *SigV4Signer) signingKey(date string) []byte {
+	k := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	k = hmacSHA256(k, s.Region)
+	k = hmacSHA256(k, s.Service)
+	return hmacSHA256(k, "aws4_request")
+}
+
+func sigV4Headers(req *http.Request) (canonical, signed string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	values := map[string]string{"host": host}
+	for k, vs := range req.Header {
+		name := strings.ToLower(k)
+		if sigV4Ignored[name] {
+			continue
+		}
+		trimmed := make([]string, len(vs))
+		for i, v := range vs {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		values[name] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(values))
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, k := range names {
+		b.WriteString(k + ":" + values[k] + "\n")
+	}
+	return b.String(), strings.Join(names, ";")
+}
+
+// sigV4URI encodes the already escaped path once more, as required for
+// every service except S3.
+func sigV4URI(req *http.Request) string {
+	p := req.URL.EscapedPath()
+	if p == "" {
+		return "/"
+	}
+	return sigV4Escape(p, false)
+}
+
+func sigV4Query(req *http.Request) string {
+	q := req.URL.Query()
+	type pair struct{ k, v string }
+	pairs := make([]pair, 0, len(q))
+	for k, vs := range q {
+		ek := sigV4Escape(k, true)
+		for _, v := range vs {
+			pairs = append(pairs, pair{ek, sigV4Escape(v, true)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].k != pairs[j].k {
+			return pairs[i].k < pairs[j].k
+		}
+		return pairs[i].v < pairs[j].v
+	})
+	out := make([]string, len(pairs))
+	for i, p := range pairs {
+		out[i] = p.k + "=" + p.v
+	}
+	return strings.Join(out, "&")
+}
+
+// sigV4Escape percent-encodes everything except RFC 3986 unreserved
+// characters (and '/' unless encodeSlash).
+func sigV4Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,131 @@
+package netclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"stp/internal/config"
+)
+
+// Vectors from the AWS Signature Version 4 test suite and the IAM example
+// in the AWS General Reference.
+func TestSigV4TestVectors(t *testing.T) {
+	signer := &SigV4Signer{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:          "us-east-1",
+		Service:         "service",
+		Now:             func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) },
+	}
+	cases := []struct {
+		name, method, url, body, contentType, service string
+		signedHeaders, signature                      string
+	}{
+		{
+			name: "get-vanilla", method: "GET", url: "https://example.amazonaws.com/",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name: "post-vanilla", method: "POST", url: "https://example.amazonaws.com/",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name: "get-vanilla-query-order-key-case", method: "GET", url: "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			signedHeaders: "host;x-amz-date",
+			signature:     "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name: "post-x-www-form-urlencoded", method: "POST", url: "https://example.amazonaws.com/",
+			body: "Param1=value1", contentType: "application/x-www-form-urlencoded",
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+		{
+			name: "iam-list-users", method: "GET", url: "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			contentType: "application/x-www-form-urlencoded; charset=utf-8", service: "iam",
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := *signer
+			if c.service != "" {
+				s.Service = c.service
+			}
+			req, _ := http.NewRequest(c.method, c.url, strings.NewReader(c.body))
+			if c.contentType != "" {
+				req.Header.Set("Content-Type", c.contentType)
+			}
+			if err := s.Sign(req, []byte(c.body)); err != nil {
+				t.Fatal(err)
+			}
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/" + s.Service + "/aws4_request, SignedHeaders=" +
+				c.signedHeaders + ", Signature=" + c.signature
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Fatalf("Authorization mismatch\n got: %s\nwant: %s", got, want)
+			}
+			if req.Header.Get("X-Amz-Date") != "20150830T123600Z" {
+				t.Fatalf("unexpected X-Amz-Date %q", req.Header.Get("X-Amz-Date"))
+			}
+		})
+	}
+}
+
+func TestSigV4SignsEveryAttemptWithEnvCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	signer, err := NewSigV4Signer(config.SigV4Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var auths []string
+	d := &fakeDoer{fn: func(req *http.Request, attempt int) (*http.Response, error) {
+		auths = append(auths, req.Header.Get("Authorization"))
+		if req.Header.Get("X-Amz-Security-Token") != "session" {
+			t.Errorf("session token header missing")
+		}
+		if attempt == 1 {
+			return &http.Response{StatusCode: 503, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+	}}
+	endpoint := "https://bedrock-runtime.eu-west-1.amazonaws.com/model/anthropic.claude-v2%3A1/converse"
+	_, err = SendWithRetry(context.Background(), d, endpoint, "ignored", map[string]interface{}{"a": 1}, RetryOptions{
+		MaxRetry: 2,
+		Auth:     AuthSigV4,
+		Signer:   signer,
+		Sleep:    func(ctx context.Context, d time.Duration) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(auths) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(auths))
+	}
+	for _, a := range auths {
+		if !strings.HasPrefix(a, "AWS4-HMAC-SHA256 Credential=AKIDENV/") || !strings.Contains(a, "/eu-west-1/bedrock/aws4_request") ||
+			!strings.Contains(a, "content-type;host;x-amz-date;x-amz-security-token") {
+			t.Fatalf("unexpected Authorization %q", a)
+		}
+	}
+
+	req, _ := http.NewRequest("POST", endpoint, nil)
+	if got := sigV4URI(req); got != "/model/anthropic.claude-v2%253A1/converse" {
+		t.Fatalf("path must be encoded twice, got %s", got)
+	}
+
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	if _, err := NewSigV4Signer(config.SigV4Config{}); err == nil {
+		t.Fatal("expected missing region to be rejected")
+	}
+}
@@ -0,0 +1,85 @@
+package netclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"stp/internal/config"
+)
+
+func TestHeaderAndIdleTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow-header":
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		case "/stalled":
+			_, _ = io.WriteString(w, `{"text":`)
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		case "/streaming":
+			// Long overall, but never idle for more than 20ms.
+			for i := 0; i < 10; i++ {
+				_, _ = io.WriteString(w, " ")
+				w.(http.Flusher).Flush()
+				time.Sleep(20 * time.Millisecond)
+			}
+			_, _ = io.WriteString(w, `{"text":"done"}`)
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	opts := RetryOptions{MaxRetry: 1, HeaderTimeout: 50 * time.Millisecond, IdleTimeout: 100 * time.Millisecond}
+	_, err := SendWithRetry(context.Background(), srv.Client(), srv.URL+"/slow-header", "", map[string]interface{}{}, opts)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || !strings.Contains(err.Error(), "response header timeout") {
+		t.Fatalf("expected header TimeoutError, got %v", err)
+	}
+
+	_, err = SendWithRetry(context.Background(), srv.Client(), srv.URL+"/stalled", "", map[string]interface{}{}, opts)
+	if !errors.As(err, &timeoutErr) || !strings.Contains(err.Error(), "stream idle timeout") {
+		t.Fatalf("expected idle TimeoutError, got %v", err)
+	}
+
+	body, err := SendWithRetry(context.Background(), srv.Client(), srv.URL+"/streaming", "", map[string]interface{}{}, opts)
+	if err != nil || !strings.Contains(string(body), "done") {
+		t.Fatalf("healthy stream must not time out: %v", err)
+	}
+}
+
+func TestConnectTimeoutFromContext(t *testing.T) {
+	cfg := config.Default()
+	cfg.ConnectTimeout = 7
+	cfg.TLSHandshakeTimeout = 3
+	_, tr, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.TLSHandshakeTimeout != 3*time.Second {
+		t.Fatalf("unexpected TLS handshake timeout %v", tr.TLSHandshakeTimeout)
+	}
+
+	// An already expired override must fail the dial immediately even
+	// though the transport default is much longer.
+	ctx := context.WithValue(context.Background(), dialTimeoutKey{}, time.Nanosecond)
+	start := time.Now()
+	if _, err := tr.DialContext(ctx, "tcp", "10.255.255.1:80"); err == nil {
+		t.Fatal("expected dial to fail")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("per-request connect timeout was ignored")
+	}
+}
@@ -0,0 +1,111 @@
+package netclient
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"stp/internal/config"
+)
+
+// NewTLSConfig builds the client TLS configuration from cfg: extra root CAs,
+// an optional client certificate for mTLS, public key pinning and the
+// minimum protocol version. It returns nil when nothing is customized.
+func NewTLSConfig(cfg config.Config) (*tls.Config, error) {
+	custom := !cfg.VerifySSL || len(cfg.CAFiles) > 0 || cfg.ClientCertFile != "" || cfg.ClientKeyFile != "" ||
+		len(cfg.PinnedPublicKeys) > 0 || cfg.MinTLSVersion != ""
+	if !custom {
+		return nil, nil
+	}
+	tc := &tls.Config{InsecureSkipVerify: !cfg.VerifySSL}
+
+	if len(cfg.CAFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		for _, path := range cfg.CAFiles {
+			pem, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("read CA file: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA file %s", path)
+			}
+		}
+		tc.RootCAs = pool
+	}
+
+	if cfg.ClientCertFile != "" || cfg.ClientKeyFile != "" {
+		if cfg.ClientCertFile == "" || cfg.ClientKeyFile == "" {
+			return nil, fmt.Errorf("ClientCertFile and ClientKeyFile must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCertFile, cfg.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	if v := strings.TrimSpace(cfg.MinTLSVersion); v != "" {
+		switch v {
+		case "1.0":
+			tc.MinVersion = tls.VersionTLS10
+		case "1.1":
+			tc.MinVersion = tls.VersionTLS11
+		case "1.2":
+			tc.MinVersion = tls.VersionTLS12
+		case "1.3":
+			tc.MinVersion = tls.VersionTLS13
+		default:
+			return nil, fmt.Errorf("unsupported MinTLSVersion %q", v)
+		}
+	}
+
+	if len(cfg.PinnedPublicKeys) > 0 {
+		pins := make(map[string]bool, len(cfg.PinnedPublicKeys))
+		for _, p := range cfg.PinnedPublicKeys {
+			p = strings.TrimPrefix(strings.TrimSpace(p), "sha256/")
+			raw, err := base64.StdEncoding.DecodeString(p)
+			if err != nil || len(raw) != sha256.Size {
+				return nil, fmt.Errorf("invalid pinned public key %q: want base64 SHA-256 of the SubjectPublicKeyInfo", p)
+			}
+			pins[p] = true
+		}
+		insecure := tc.InsecureSkipVerify
+		// Only certificates of a verified chain are matched: the peer list
+		// is sent by the server and may carry any certificate. Without
+		// verification only the leaf, whose key the server proved to hold,
+		// can be trusted.
+		tc.VerifyConnection = func(cs tls.ConnectionState) error {
+			var candidates []*x509.Certificate
+			if insecure {
+				if len(cs.PeerCertificates) > 0 {
+					candidates = cs.PeerCertificates[:1]
+				}
+			} else {
+				for _, chain := range cs.VerifiedChains {
+					candidates = append(candidates, chain...)
+				}
+			}
+			for _, cert := range candidates {
+				if pins[PublicKeyPin(cert)] {
+					return nil
+				}
+			}
+			return fmt.Errorf("server public key does not match any pinned key")
+		}
+	}
+	return tc, nil
+}
+
+// PublicKeyPin returns the base64 SHA-256 of cert's SubjectPublicKeyInfo, the
+// value expected in PinnedPublicKeys (optionally prefixed with "sha256/").
+func PublicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
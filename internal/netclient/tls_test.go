@@ -0,0 +1,229 @@
+package netclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"stp/internal/config"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, isCA bool, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:         isCA,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+
+		BasicConstraintsValid: true,
+	}
+	if isCA {
+		tmpl.ExtKeyUsage = nil
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeTemp(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTLSCustomCAAndClientCertificate(t *testing.T) {
+	ca := newTestCert(t, "test ca", nil, true, 0)
+	server := newTestCert(t, "127.0.0.1", ca, false, x509.ExtKeyUsageServerAuth)
+	client := newTestCert(t, "stp client", ca, false, x509.ExtKeyUsageClientAuth)
+
+	clientPool := x509.NewCertPool()
+	clientPool.AddCert(ca.cert)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"cn":"`+r.TLS.PeerCertificates[0].Subject.CommonName+`"}`)
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{server.cert.Raw}, PrivateKey: server.key}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientPool,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	cfg := config.Default()
+	cfg.CAFiles = []string{writeTemp(t, "ca.pem", ca.certPEM)}
+	cfg.ClientCertFile = writeTemp(t, "client.pem", client.certPEM)
+	cfg.ClientKeyFile = writeTemp(t, "client.key", client.keyPEM)
+	cfg.MinTLSVersion = "1.2"
+	cfg.PinnedPublicKeys = []string{"sha256/" + PublicKeyPin(server.cert)}
+	cli, tr, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.CloseIdleConnections()
+
+	body, err := SendWithRetry(context.Background(), cli, srv.URL, "", map[string]interface{}{}, RetryOptions{MaxRetry: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"cn":"stp client"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	cfg.ClientCertFile, cfg.ClientKeyFile = "", ""
+	cli, tr, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.CloseIdleConnections()
+	if _, err := SendWithRetry(context.Background(), cli, srv.URL, "", map[string]interface{}{}, RetryOptions{MaxRetry: 1}); err == nil {
+		t.Fatalf("mTLS server should reject a client without certificate")
+	}
+}
+
+func TestTLSPinMismatchAndDefaultTrust(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{}`)
+	}))
+	defer srv.Close()
+
+	cfg := config.Default()
+	cli, tr, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.CloseIdleConnections()
+	if _, err := SendWithRetry(context.Background(), cli, srv.URL, "", map[string]interface{}{}, RetryOptions{MaxRetry: 1}); err == nil {
+		t.Fatalf("untrusted test certificate must be rejected by default")
+	}
+
+	other := newTestCert(t, "other", nil, true, 0)
+	cfg.VerifySSL = false
+	cfg.PinnedPublicKeys = []string{PublicKeyPin(other.cert)}
+	cli, tr, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.CloseIdleConnections()
+	if _, err := SendWithRetry(context.Background(), cli, srv.URL, "", map[string]interface{}{}, RetryOptions{MaxRetry: 1}); err == nil {
+		t.Fatalf("pin mismatch must fail even with VerifySSL=false")
+	}
+
+	cfg.PinnedPublicKeys = []string{PublicKeyPin(srv.Certificate())}
+	cli, tr, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.CloseIdleConnections()
+	if _, err := SendWithRetry(context.Background(), cli, srv.URL, "", map[string]interface{}{}, RetryOptions{MaxRetry: 1}); err != nil {
+		t.Fatalf("matching pin should succeed: %v", err)
+	}
+
+	for _, bad := range []config.Config{
+		{VerifySSL: true, MinTLSVersion: "1.4"},
+		{VerifySSL: true, ClientCertFile: "only-cert.pem"},
+		{VerifySSL: true, PinnedPublicKeys: []string{"not-base64"}},
+		{VerifySSL: true, CAFiles: []string{writeTemp(t, "empty.pem", []byte("nope"))}},
+	} {
+		if _, err := NewTLSConfig(bad); err == nil {
+			t.Fatalf("expected error for %#v", bad)
+		}
+	}
+}
+
+func TestTLSPinIgnoresUnverifiedChainCertificates(t *testing.T) {
+	ca := newTestCert(t, "test ca", nil, true, 0)
+	attacker := newTestCert(t, "127.0.0.1", ca, false, x509.ExtKeyUsageServerAuth)
+	pinned := newTestCert(t, "127.0.0.1", nil, false, x509.ExtKeyUsageServerAuth)
+
+	// The server presents an unpinned leaf followed by the pinned
+	// certificate, which is not part of the verified chain.
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{}`)
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{attacker.cert.Raw, pinned.cert.Raw}, PrivateKey: attacker.key}},
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, verify := range []bool{true, false} {
+		cfg := config.Default()
+		cfg.VerifySSL = verify
+		cfg.CAFiles = []string{writeTemp(t, "ca.pem", ca.certPEM)}
+		cfg.PinnedPublicKeys = []string{PublicKeyPin(pinned.cert)}
+		cli, tr, err := New(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tr.CloseIdleConnections()
+		if _, err := SendWithRetry(context.Background(), cli, srv.URL, "", map[string]interface{}{}, RetryOptions{MaxRetry: 1}); err == nil {
+			t.Fatalf("VerifySSL=%v: a pinned certificate appended to the chain must not pass", verify)
+		}
+
+		cfg.PinnedPublicKeys = []string{PublicKeyPin(ca.cert)}
+		cli, tr, err = New(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tr.CloseIdleConnections()
+		_, err = SendWithRetry(context.Background(), cli, srv.URL, "", map[string]interface{}{}, RetryOptions{MaxRetry: 1})
+		if verify && err != nil {
+			t.Fatalf("pinning the CA of the verified chain should succeed: %v", err)
+		}
+		if !verify && err == nil {
+			t.Fatalf("without verification only the leaf may match a pin")
+		}
+	}
+}
@@ -1,29 +1,77 @@
 package request
 
+import "stp/internal/config"
+
+// SystemRoleNone merges the prompt into the first user message instead of
+// sending a separate system/developer message.
+const SystemRoleNone = "none"
+
 type BuildInput struct {
 	Model       string
 	Temperature float64
 	MaxTokens   int
 	Prompt      string
 	UserText    string
-	Extra       map[string]interface{}
+	// SystemRole overrides the role of the prompt message; empty means the
+	// matched model profile decides, falling back to "developer".
+	SystemRole string
+	// Examples are few-shot exchanges inserted between prompt and selection.
+	Examples []config.Example
+	// Extra is applied to the payload as an RFC 7386 merge patch.
+	Extra map[string]interface{}
+	// Profiles are user model profiles, consulted before DefaultModelProfiles.
+	Profiles []config.ModelProfile
+	// Format selects the request schema: FormatOpenAI (default) or
+	// FormatBedrockConverse.
+	Format string
 }
 
 func BuildPayload(in BuildInput) map[string]interface{} {
+	if IsConverse(in.Format) {
+		return buildConversePayload(in)
+	}
+	// ExtraConfig may switch the model, so the profile follows the final name.
+	model := in.Model
+	if m, ok := in.Extra["model"].(string); ok && m != "" {
+		model = m
+	}
+	profile, _ := ResolveProfile(in.Profiles, model)
+	systemRole := in.SystemRole
+	if systemRole == "" {
+		systemRole = profile.SystemRole
+	}
+	if systemRole == "" {
+		systemRole = "developer"
+	}
+
 	payload := make(map[string]interface{})
 	if in.Model != "" {
 		payload["model"] = in.Model
 	}
-	payload["messages"] = []map[string]string{
-		{"role": "developer", "content": in.Prompt},
-		{"role": "user", "content": in.UserText},
-	}
+	payload["messages"] = buildMessages(systemRole, in.Prompt, in.Examples, in.UserText)
 	if in.MaxTokens > 0 {
 		payload["max_tokens"] = in.MaxTokens
 	}
 	payload["temperature"] = in.Temperature
-	for k, v := range in.Extra {
-		payload[k] = v
+	applyProfile(payload, profile)
+	return ApplyMergePatch(payload, in.Extra)
+}
+
+func buildMessages(systemRole, prompt string, examples []config.Example, userText string) []map[string]string {
+	msgs := make([]map[string]string, 0, 2+2*len(examples))
+	if systemRole != SystemRoleNone {
+		msgs = append(msgs, map[string]string{"role": systemRole, "content": prompt})
+	}
+	for _, ex := range examples {
+		msgs = append(msgs,
+			map[string]string{"role": "user", "content": ex.User},
+			map[string]string{"role": "assistant", "content": ex.Assistant},
+		)
+	}
+	msgs = append(msgs, map[string]string{"role": "user", "content": userText})
+	if systemRole == SystemRoleNone {
+		first := msgs[0]
+		first["content"] = prompt + "\n\n" + first["content"]
 	}
-	return StripEmptyFields(payload)
+	return msgs
 }
@@ -0,0 +1,40 @@
+package request
+
+import (
+	"reflect"
+	"testing"
+
+	"stp/internal/config"
+)
+
+func TestBuildPayloadSystemRoleAndExamples(t *testing.T) {
+	examples := []config.Example{{User: "hola", Assistant: "hello"}}
+	payload := BuildPayload(BuildInput{
+		Prompt:     "translate",
+		UserText:   "adios",
+		SystemRole: "system",
+		Examples:   examples,
+	})
+	want := []map[string]string{
+		{"role": "system", "content": "translate"},
+		{"role": "user", "content": "hola"},
+		{"role": "assistant", "content": "hello"},
+		{"role": "user", "content": "adios"},
+	}
+	if got := payload["messages"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected messages: %#v", got)
+	}
+}
+
+func TestBuildPayloadSystemRoleNone(t *testing.T) {
+	payload := BuildPayload(BuildInput{Prompt: "translate", UserText: "adios", SystemRole: SystemRoleNone})
+	want := []map[string]string{{"role": "user", "content": "translate\n\nadios"}}
+	if got := payload["messages"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected messages: %#v", got)
+	}
+
+	payload = BuildPayload(BuildInput{Prompt: "translate", UserText: "adios"})
+	if msgs := payload["messages"].([]map[string]string); msgs[0]["role"] != "developer" {
+		t.Fatalf("default role should stay developer, got %s", msgs[0]["role"])
+	}
+}
@@ -0,0 +1,73 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Request formats.
+const (
+	FormatOpenAI          = "openai"
+	FormatBedrockConverse = "bedrock-converse"
+)
+
+// ConverseTEXTPath locates the reply text in a Bedrock Converse response.
+const ConverseTEXTPath = "output.message.content[0].text"
+
+// ValidateFormat reports whether format is known; empty means openai.
+func ValidateFormat(format string) error {
+	switch normalizeFormat(format) {
+	case FormatOpenAI, FormatBedrockConverse:
+		return nil
+	default:
+		return fmt.Errorf("unknown RequestFormat %q (want openai or bedrock-converse)", format)
+	}
+}
+
+func normalizeFormat(format string) string {
+	f := strings.ToLower(strings.TrimSpace(format))
+	if f == "" {
+		return FormatOpenAI
+	}
+	return f
+}
+
+// IsConverse reports whether format selects the Bedrock Converse API.
+func IsConverse(format string) bool {
+	return normalizeFormat(format) == FormatBedrockConverse
+}
+
+// buildConversePayload builds a Bedrock Converse request. The model is part
+// of the endpoint URL, the prompt goes to "system" and model profiles do
+// not apply because Converse has its own parameter names.
+func buildConversePayload(in BuildInput) map[string]interface{} {
+	role := in.SystemRole
+	payload := make(map[string]interface{})
+	msgs := make([]interface{}, 0, 1+2*len(in.Examples))
+	for _, ex := range in.Examples {
+		msgs = append(msgs, converseMessage("user", ex.User), converseMessage("assistant", ex.Assistant))
+	}
+	msgs = append(msgs, converseMessage("user", in.UserText))
+	if role == SystemRoleNone {
+		first := msgs[0].(map[string]interface{})
+		block := first["content"].([]interface{})[0].(map[string]interface{})
+		block["text"] = in.Prompt + "\n\n" + block["text"].(string)
+	} else if in.Prompt != "" {
+		payload["system"] = []interface{}{map[string]interface{}{"text": in.Prompt}}
+	}
+	payload["messages"] = msgs
+
+	inference := map[string]interface{}{"temperature": in.Temperature}
+	if in.MaxTokens > 0 {
+		inference["maxTokens"] = in.MaxTokens
+	}
+	payload["inferenceConfig"] = inference
+	return ApplyMergePatch(payload, in.Extra)
+}
+
+func converseMessage(role, text string) map[string]interface{} {
+	return map[string]interface{}{
+		"role":    role,
+		"content": []interface{}{map[string]interface{}{"text": text}},
+	}
+}
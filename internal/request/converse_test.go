@@ -0,0 +1,39 @@
+package request
+
+import (
+	"encoding/json"
+	"testing"
+
+	"stp/internal/config"
+)
+
+func TestBuildConversePayload(t *testing.T) {
+	payload := BuildPayload(BuildInput{
+		Format:      FormatBedrockConverse,
+		Model:       "o1-mini", // profiles must not rename anything here
+		Temperature: 0.2,
+		MaxTokens:   512,
+		Prompt:      "translate",
+		UserText:    "adios",
+		Examples:    []config.Example{{User: "hola", Assistant: "hello"}},
+		Extra:       map[string]interface{}{"additionalModelRequestFields": map[string]interface{}{"top_k": 50}},
+	})
+	got, _ := json.Marshal(payload)
+	want := `{"additionalModelRequestFields":{"top_k":50},"inferenceConfig":{"maxTokens":512,"temperature":0.2},` +
+		`"messages":[{"content":[{"text":"hola"}],"role":"user"},{"content":[{"text":"hello"}],"role":"assistant"},` +
+		`{"content":[{"text":"adios"}],"role":"user"}],"system":[{"text":"translate"}]}`
+	if string(got) != want {
+		t.Fatalf("unexpected payload\n got: %s\nwant: %s", got, want)
+	}
+
+	payload = BuildPayload(BuildInput{Format: "Bedrock-Converse", Prompt: "translate", UserText: "adios", SystemRole: SystemRoleNone})
+	got, _ = json.Marshal(payload)
+	want = `{"inferenceConfig":{"temperature":0},"messages":[{"content":[{"text":"translate\n\nadios"}],"role":"user"}]}`
+	if string(got) != want {
+		t.Fatalf("unexpected payload with SystemRole none: %s", got)
+	}
+
+	if err := ValidateFormat("anthropic"); err == nil {
+		t.Fatal("expected unknown format to be rejected")
+	}
+}
@@ -53,15 +53,24 @@ func ExtractRuntimeOverrides(extra map[string]interface{}) (RuntimeOverrides, ma
 	return out, clean
 }
 
+// MergeExtra composes two merge patches so that applying the result equals
+// applying base and then override. Nested objects are merged recursively and
+// null values are kept so they still delete the field from the payload.
 func MergeExtra(base map[string]interface{}, override map[string]interface{}) map[string]interface{} {
 	if base == nil && override == nil {
 		return nil
 	}
-	out := make(map[string]interface{})
+	out := make(map[string]interface{}, len(base)+len(override))
 	for k, v := range base {
 		out[k] = v
 	}
 	for k, v := range override {
+		om, ok := v.(map[string]interface{})
+		bm, baseIsMap := out[k].(map[string]interface{})
+		if ok && baseIsMap {
+			out[k] = MergeExtra(bm, om)
+			continue
+		}
 		out[k] = v
 	}
 	if len(out) == 0 {
@@ -69,51 +78,3 @@ func MergeExtra(base map[string]interface{}, override map[string]interface{}) ma
 	}
 	return out
 }
-
-func StripEmptyFields(m map[string]interface{}) map[string]interface{} {
-	if m == nil {
-		return nil
-	}
-	out := make(map[string]interface{}, len(m))
-	for k, v := range m {
-		cleaned := cleanValue(v)
-		if cleaned == nil {
-			continue
-		}
-		out[k] = cleaned
-	}
-	return out
-}
-
-func cleanValue(v interface{}) interface{} {
-	if v == nil {
-		return nil
-	}
-	switch t := v.(type) {
-	case string:
-		if strings.TrimSpace(t) == "" {
-			return nil
-		}
-		return t
-	case map[string]interface{}:
-		m := StripEmptyFields(t)
-		if len(m) == 0 {
-			return nil
-		}
-		return m
-	case []interface{}:
-		arr := make([]interface{}, 0, len(t))
-		for _, item := range t {
-			cleaned := cleanValue(item)
-			if cleaned != nil {
-				arr = append(arr, cleaned)
-			}
-		}
-		if len(arr) == 0 {
-			return nil
-		}
-		return arr
-	default:
-		return v
-	}
-}
@@ -14,18 +14,42 @@ func TestMergeExtraAndNullDeletion(t *testing.T) {
 		"new_field":  true,
 	}
 	merged := MergeExtra(global, entry)
-	clean := StripEmptyFields(merged)
+	clean := ApplyMergePatch(map[string]interface{}{"max_tokens": 50}, merged)
 	if _, ok := clean["max_tokens"]; ok {
 		t.Fatalf("nil max_tokens should be removed")
 	}
-	if _, ok := clean["verbosity"]; ok {
-		t.Fatalf("empty verbosity should be removed")
+	if v, ok := clean["verbosity"]; !ok || v != "" {
+		t.Fatalf("empty verbosity should be kept as a value, got %#v", v)
 	}
 	if clean["new_field"] != true {
 		t.Fatalf("new_field should remain")
 	}
 }
 
+func TestMergeExtraDeepMerge(t *testing.T) {
+	global := map[string]interface{}{
+		"response_format": map[string]interface{}{"type": "text", "strict": true},
+	}
+	entry := map[string]interface{}{
+		"response_format": map[string]interface{}{"type": "json_object", "strict": nil},
+	}
+	payload := BuildPayload(BuildInput{
+		Prompt:   "p",
+		UserText: "u",
+		Extra:    MergeExtra(global, entry),
+	})
+	rf, ok := payload["response_format"].(map[string]interface{})
+	if !ok || rf["type"] != "json_object" {
+		t.Fatalf("nested object should be merged: %#v", payload["response_format"])
+	}
+	if _, ok := rf["strict"]; ok {
+		t.Fatalf("nested null should delete strict")
+	}
+	if global["response_format"].(map[string]interface{})["type"] != "text" {
+		t.Fatalf("global extra must not be mutated")
+	}
+}
+
 func TestExtractRuntimeOverrides(t *testing.T) {
 	extra := map[string]interface{}{
 		"APIEndpoint": "https://x",
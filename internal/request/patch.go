@@ -0,0 +1,319 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ApplyMergePatch applies patch to target following RFC 7386: objects are
+// merged recursively, null removes a member and any other value replaces
+// the target value. Neither argument is modified.
+func ApplyMergePatch(target, patch map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(target)+len(patch))
+	for k, v := range target {
+		out[k] = v
+	}
+	for k, pv := range patch {
+		if pv == nil {
+			delete(out, k)
+			continue
+		}
+		pm, ok := pv.(map[string]interface{})
+		if !ok {
+			out[k] = deepCopy(pv)
+			continue
+		}
+		tm, _ := out[k].(map[string]interface{})
+		out[k] = ApplyMergePatch(tm, pm)
+	}
+	return out
+}
+
+// PatchOp is one RFC 6902 JSON Patch operation.
+type PatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+func ParseJSONPatch(raw string) ([]PatchOp, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	var ops []PatchOp
+	if err := json.Unmarshal([]byte(raw), &ops); err != nil {
+		return nil, err
+	}
+	for i, op := range ops {
+		switch op.Op {
+		case "add", "remove", "replace", "move", "copy", "test":
+		default:
+			return nil, fmt.Errorf("patch op %d: unsupported op %q", i, op.Op)
+		}
+	}
+	return ops, nil
+}
+
+// ApplyJSONPatch applies ops to a copy of doc. The patch is atomic: on error
+// the original document is left untouched and the error is returned.
+func ApplyJSONPatch(doc map[string]interface{}, ops []PatchOp) (map[string]interface{}, error) {
+	if len(ops) == 0 {
+		return doc, nil
+	}
+	var root interface{}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return doc, err
+	}
+	if err := json.Unmarshal(b, &root); err != nil {
+		return doc, err
+	}
+	for i, op := range ops {
+		root, err = applyPatchOp(root, op)
+		if err != nil {
+			return doc, fmt.Errorf("patch op %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	out, ok := root.(map[string]interface{})
+	if !ok {
+		return doc, fmt.Errorf("patch result is not an object")
+	}
+	return out, nil
+}
+
+func applyPatchOp(root interface{}, op PatchOp) (interface{}, error) {
+	switch op.Op {
+	case "add":
+		return pointerAdd(root, op.Path, deepCopy(op.Value))
+	case "remove":
+		out, _, err := pointerRemove(root, op.Path)
+		return out, err
+	case "replace":
+		out, _, err := pointerRemove(root, op.Path)
+		if err != nil {
+			return root, err
+		}
+		return pointerAdd(out, op.Path, deepCopy(op.Value))
+	case "move":
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return root, fmt.Errorf("cannot move a value into its own child")
+		}
+		out, v, err := pointerRemove(root, op.From)
+		if err != nil {
+			return root, err
+		}
+		return pointerAdd(out, op.Path, v)
+	case "copy":
+		v, err := pointerGet(root, op.From)
+		if err != nil {
+			return root, err
+		}
+		return pointerAdd(root, op.Path, deepCopy(v))
+	case "test":
+		v, err := pointerGet(root, op.Path)
+		if err != nil {
+			return root, err
+		}
+		if !jsonEqual(v, op.Value) {
+			return root, fmt.Errorf("test failed")
+		}
+		return root, nil
+	}
+	return root, fmt.Errorf("unsupported op %q", op.Op)
+}
+
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", p)
+	}
+	parts := strings.Split(p[1:], "/")
+	for i, part := range parts {
+		part = strings.ReplaceAll(part, "~1", "/")
+		parts[i] = strings.ReplaceAll(part, "~0", "~")
+	}
+	return parts, nil
+}
+
+func arrayIndex(token string, n int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return n, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	idx, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	limit := n - 1
+	if allowEnd {
+		limit = n
+	}
+	if idx < 0 || idx > limit {
+		return 0, fmt.Errorf("array index %d out of range", idx)
+	}
+	return idx, nil
+}
+
+func pointerGet(root interface{}, path string) (interface{}, error) {
+	parts, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+	cur := root
+	for _, part := range parts {
+		switch c := cur.(type) {
+		case map[string]interface{}:
+			v, ok := c[part]
+			if !ok {
+				return nil, fmt.Errorf("path %q not found", path)
+			}
+			cur = v
+		case []interface{}:
+			idx, err := arrayIndex(part, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			cur = c[idx]
+		default:
+			return nil, fmt.Errorf("path %q not found", path)
+		}
+	}
+	return cur, nil
+}
+
+// pointerAdd inserts v at path and returns the (possibly new) root.
+func pointerAdd(root interface{}, path string, v interface{}) (interface{}, error) {
+	parts, err := parsePointer(path)
+	if err != nil {
+		return root, err
+	}
+	if len(parts) == 0 {
+		return v, nil
+	}
+	return setIn(root, parts, func(container interface{}, last string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[last] = v
+			return c, nil
+		case []interface{}:
+			idx, err := arrayIndex(last, len(c), true)
+			if err != nil {
+				return c, err
+			}
+			c = append(c, nil)
+			copy(c[idx+1:], c[idx:])
+			c[idx] = v
+			return c, nil
+		}
+		return container, fmt.Errorf("parent of %q is not a container", path)
+	})
+}
+
+// pointerRemove deletes the value at path, returning the new root and the removed value.
+func pointerRemove(root interface{}, path string) (interface{}, interface{}, error) {
+	parts, err := parsePointer(path)
+	if err != nil {
+		return root, nil, err
+	}
+	if len(parts) == 0 {
+		return nil, root, nil
+	}
+	var removed interface{}
+	out, err := setIn(root, parts, func(container interface{}, last string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			v, ok := c[last]
+			if !ok {
+				return c, fmt.Errorf("path %q not found", path)
+			}
+			removed = v
+			delete(c, last)
+			return c, nil
+		case []interface{}:
+			idx, err := arrayIndex(last, len(c), false)
+			if err != nil {
+				return c, err
+			}
+			removed = c[idx]
+			return append(c[:idx], c[idx+1:]...), nil
+		}
+		return container, fmt.Errorf("path %q not found", path)
+	})
+	return out, removed, err
+}
+
+// setIn walks to the parent of the final token and lets fn rewrite it; slices
+// may be reallocated, so every level stores the returned value back.
+func setIn(cur interface{}, parts []string, fn func(container interface{}, last string) (interface{}, error)) (interface{}, error) {
+	if len(parts) == 1 {
+		return fn(cur, parts[0])
+	}
+	switch c := cur.(type) {
+	case map[string]interface{}:
+		child, ok := c[parts[0]]
+		if !ok {
+			return cur, fmt.Errorf("path segment %q not found", parts[0])
+		}
+		next, err := setIn(child, parts[1:], fn)
+		if err != nil {
+			return cur, err
+		}
+		c[parts[0]] = next
+		return c, nil
+	case []interface{}:
+		idx, err := arrayIndex(parts[0], len(c), false)
+		if err != nil {
+			return cur, err
+		}
+		next, err := setIn(c[idx], parts[1:], fn)
+		if err != nil {
+			return cur, err
+		}
+		c[idx] = next
+		return c, nil
+	}
+	return cur, fmt.Errorf("path segment %q not found", parts[0])
+}
+
+func deepCopy(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[k] = deepCopy(val)
+		}
+		return m
+	case []interface{}:
+		arr := make([]interface{}, len(t))
+		for i, val := range t {
+			arr[i] = deepCopy(val)
+		}
+		return arr
+	default:
+		return v
+	}
+}
+
+func jsonEqual(a, b interface{}) bool {
+	ab, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	var av, bv interface{}
+	_ = json.Unmarshal(ab, &av)
+	_ = json.Unmarshal(bb, &bv)
+	return reflect.DeepEqual(av, bv)
+}
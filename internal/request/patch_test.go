@@ -0,0 +1,88 @@
+package request
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyMergePatchRFC7386(t *testing.T) {
+	target := map[string]interface{}{
+		"title": "Goodbye!",
+		"author": map[string]interface{}{
+			"givenName":  "John",
+			"familyName": "Doe",
+		},
+		"tags":    []interface{}{"example", "sample"},
+		"content": "This will be unchanged",
+	}
+	patch := map[string]interface{}{
+		"title":       "Hello!",
+		"phoneNumber": "+01-123-456-7890",
+		"author":      map[string]interface{}{"familyName": nil},
+		"tags":        []interface{}{"example"},
+	}
+	want := map[string]interface{}{
+		"title":       "Hello!",
+		"author":      map[string]interface{}{"givenName": "John"},
+		"tags":        []interface{}{"example"},
+		"content":     "This will be unchanged",
+		"phoneNumber": "+01-123-456-7890",
+	}
+	got := ApplyMergePatch(target, patch)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected merge result: %#v", got)
+	}
+	if _, ok := target["author"].(map[string]interface{})["familyName"]; !ok {
+		t.Fatalf("target must not be mutated")
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	doc := map[string]interface{}{
+		"stop":     []interface{}{"a"},
+		"messages": []map[string]string{{"role": "user", "content": "hi"}},
+	}
+	ops, err := ParseJSONPatch(`[
+		{"op":"add","path":"/stop/-","value":"b"},
+		{"op":"add","path":"/messages/0","value":{"role":"system","content":"s"}},
+		{"op":"test","path":"/messages/1/role","value":"user"},
+		{"op":"replace","path":"/stop/0","value":""},
+		{"op":"copy","from":"/stop","path":"/stop_copy"},
+		{"op":"remove","path":"/stop_copy/1"}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ApplyJSONPatch(doc, ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got["stop"], []interface{}{"", "b"}) {
+		t.Fatalf("unexpected stop: %#v", got["stop"])
+	}
+	if !reflect.DeepEqual(got["stop_copy"], []interface{}{""}) {
+		t.Fatalf("unexpected stop_copy: %#v", got["stop_copy"])
+	}
+	msgs := got["messages"].([]interface{})
+	if len(msgs) != 2 || msgs[0].(map[string]interface{})["role"] != "system" {
+		t.Fatalf("unexpected messages: %#v", msgs)
+	}
+}
+
+func TestApplyJSONPatchFailureIsAtomic(t *testing.T) {
+	doc := map[string]interface{}{"a": 1.0}
+	ops := []PatchOp{
+		{Op: "add", Path: "/b", Value: 2.0},
+		{Op: "remove", Path: "/missing"},
+	}
+	got, err := ApplyJSONPatch(doc, ops)
+	if err == nil {
+		t.Fatalf("expected error for missing path")
+	}
+	if _, ok := got["b"]; ok || len(doc) != 1 {
+		t.Fatalf("failed patch must leave document untouched: %#v", got)
+	}
+	if _, err := ParseJSONPatch(`[{"op":"merge","path":"/a"}]`); err == nil {
+		t.Fatalf("expected unsupported op error")
+	}
+}
@@ -0,0 +1,65 @@
+package request
+
+import (
+	"path"
+	"strings"
+
+	"stp/internal/config"
+)
+
+// DefaultModelProfiles covers model families that reject the classic chat
+// parameters. User profiles are consulted before these.
+var DefaultModelProfiles = []config.ModelProfile{
+	{Match: "o1*", Rename: map[string]string{"max_tokens": "max_completion_tokens"}, Drop: []string{"temperature"}},
+	{Match: "o3*", Rename: map[string]string{"max_tokens": "max_completion_tokens"}, Drop: []string{"temperature"}},
+	{Match: "o4*", Rename: map[string]string{"max_tokens": "max_completion_tokens"}, Drop: []string{"temperature"}},
+	{Match: "gpt-5*", Rename: map[string]string{"max_tokens": "max_completion_tokens"}, Drop: []string{"temperature"}},
+}
+
+// ResolveProfile returns the first profile matching model, looking at user
+// profiles first and then DefaultModelProfiles. A pattern without "/" also
+// matches the last path segment of model, so that "o1*" covers provider
+// prefixed names such as "openai/o1-mini".
+func ResolveProfile(user []config.ModelProfile, model string) (config.ModelProfile, bool) {
+	model = strings.ToLower(strings.TrimSpace(model))
+	if model == "" {
+		return config.ModelProfile{}, false
+	}
+	for _, list := range [][]config.ModelProfile{user, DefaultModelProfiles} {
+		for _, p := range list {
+			pattern := strings.ToLower(strings.TrimSpace(p.Match))
+			if pattern == "" {
+				continue
+			}
+			if matchModel(pattern, model) {
+				return p, true
+			}
+		}
+	}
+	return config.ModelProfile{}, false
+}
+
+func matchModel(pattern, model string) bool {
+	if ok, err := path.Match(pattern, model); err == nil && ok {
+		return true
+	}
+	if strings.Contains(pattern, "/") || !strings.Contains(model, "/") {
+		return false
+	}
+	ok, err := path.Match(pattern, path.Base(model))
+	return err == nil && ok
+}
+
+func applyProfile(payload map[string]interface{}, p config.ModelProfile) {
+	for from, to := range p.Rename {
+		v, ok := payload[from]
+		if !ok || to == "" {
+			continue
+		}
+		delete(payload, from)
+		payload[to] = v
+	}
+	for _, k := range p.Drop {
+		delete(payload, k)
+	}
+}
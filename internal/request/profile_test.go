@@ -0,0 +1,81 @@
+package request
+
+import (
+	"testing"
+
+	"stp/internal/config"
+)
+
+func TestBuildPayloadAppliesDefaultProfile(t *testing.T) {
+	payload := BuildPayload(BuildInput{
+		Model:       "o3-mini",
+		Temperature: 0.2,
+		MaxTokens:   100,
+		Prompt:      "p",
+		UserText:    "u",
+	})
+	if _, ok := payload["temperature"]; ok {
+		t.Fatalf("temperature should be dropped for reasoning models")
+	}
+	if _, ok := payload["max_tokens"]; ok {
+		t.Fatalf("max_tokens should be renamed")
+	}
+	if payload["max_completion_tokens"] != 100 {
+		t.Fatalf("expected max_completion_tokens=100, got %#v", payload["max_completion_tokens"])
+	}
+}
+
+func TestResolveProfileProviderPrefix(t *testing.T) {
+	for _, model := range []string{"openai/o1-mini", "azure/gpt-5", "openrouter/openai/o3"} {
+		if _, ok := ResolveProfile(nil, model); !ok {
+			t.Fatalf("%s should match a default profile", model)
+		}
+	}
+	if _, ok := ResolveProfile(nil, "openai/gpt-4o"); ok {
+		t.Fatal("openai/gpt-4o should not match")
+	}
+	user := []config.ModelProfile{{Match: "local/*", Drop: []string{"temperature"}}}
+	if p, ok := ResolveProfile(user, "local/o1-mini"); !ok || len(p.Drop) != 1 || len(p.Rename) != 0 {
+		t.Fatalf("a pattern with / must match the full name first, got %+v", p)
+	}
+}
+
+func TestBuildPayloadProfileFollowsExtraModel(t *testing.T) {
+	payload := BuildPayload(BuildInput{
+		Model:     "gpt-4.1-mini",
+		MaxTokens: 10,
+		Prompt:    "p",
+		UserText:  "u",
+		Extra:     map[string]interface{}{"model": "GPT-5-mini", "temperature": 1},
+	})
+	if payload["max_completion_tokens"] != 10 {
+		t.Fatalf("profile should match the ExtraConfig model: %#v", payload)
+	}
+	if payload["temperature"] != 1 {
+		t.Fatalf("explicit ExtraConfig values must still win: %#v", payload["temperature"])
+	}
+}
+
+func TestBuildPayloadUserProfile(t *testing.T) {
+	profiles := []config.ModelProfile{
+		{Match: "local-*", Drop: []string{"max_tokens"}, SystemRole: "system"},
+		{Match: "o3*"},
+	}
+	payload := BuildPayload(BuildInput{Model: "local-qwen", MaxTokens: 10, Prompt: "p", UserText: "u", Profiles: profiles})
+	if _, ok := payload["max_tokens"]; ok {
+		t.Fatalf("user profile should drop max_tokens")
+	}
+	msgs := payload["messages"].([]map[string]string)
+	if msgs[0]["role"] != "system" {
+		t.Fatalf("unexpected system role: %s", msgs[0]["role"])
+	}
+
+	payload = BuildPayload(BuildInput{Model: "o3", Temperature: 0.5, Prompt: "p", UserText: "u", Profiles: profiles})
+	if payload["temperature"] != 0.5 {
+		t.Fatalf("user profile should shadow the built-in one")
+	}
+	payload = BuildPayload(BuildInput{Model: "gpt-4o", Temperature: 0.5, MaxTokens: 5, Prompt: "p", UserText: "u"})
+	if payload["temperature"] != 0.5 || payload["max_tokens"] != 5 {
+		t.Fatalf("unmatched models keep the classic parameters: %#v", payload)
+	}
+}
@@ -0,0 +1,334 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Prefix marks a config value that names a secret, e.g. "secret:openai".
+const Prefix = "secret:"
+
+// PassphraseEnv names the environment variable holding the passphrase of
+// passphrase protected stores.
+const PassphraseEnv = "STP_SECRET_PASSPHRASE"
+
+// Key derivation modes recorded in the store file.
+const (
+	KDFPassphrase = "pbkdf2-sha256"
+	KDFKeyFile    = "keyfile"
+)
+
+const (
+	defaultIterations = 600000
+	// checkName is the associated data of the value that verifies the key.
+	checkName = "\x00check"
+)
+
+// ErrNotFound is returned by Get for an unknown name.
+var ErrNotFound = errors.New("secret not found")
+
+// Options select the key of a store. A store created with a Passphrase
+// derives its key from it; otherwise a random key is kept in KeyFile
+// (DefaultKeyFile when empty), which stays on this machine.
+type Options struct {
+	Passphrase string
+	KeyFile    string
+}
+
+// Store is an encrypted secrets file. Each value is sealed with AES-256-GCM
+// using its name as associated data, so values cannot be swapped between
+// names. Values are only decrypted by Get. It is safe for concurrent use.
+type Store struct {
+	path string
+	opts Options
+
+	mu   sync.Mutex
+	key  []byte
+	file storeFile
+}
+
+type storeFile struct {
+	Version    int               `json:"Version"`
+	KDF        string            `json:"KDF"`
+	Salt       string            `json:"Salt,omitempty"`
+	Iterations int               `json:"Iterations,omitempty"`
+	Check      string            `json:"Check"`
+	Secrets    map[string]string `json:"Secrets"`
+}
+
+// DefaultKeyFile returns the machine-local key file in the user config
+// directory.
+func DefaultKeyFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "stp", "secret.key"), nil
+}
+
+// Open reads the store at path. A missing file is an empty store that is
+// created by the first Set. The key is only derived by Get and Set, so
+// listing and removing secrets needs neither passphrase nor key file.
+func Open(path string, opts Options) (*Store, error) {
+	s := &Store{path: path, opts: opts}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.file); err != nil {
+		return nil, fmt.Errorf("secrets file %s: %w", path, err)
+	}
+	if s.file.Secrets == nil {
+		s.file.Secrets = map[string]string{}
+	}
+	return s, nil
+}
+
+// Path returns the file of the store.
+func (s *Store) Path() string { return s.path }
+
+// Names returns the names of all secrets, sorted.
+func (s *Store) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.file.Secrets))
+	for name := range s.file.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get decrypts the secret name.
+func (s *Store) Get(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sealed, ok := s.file.Secrets[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrNotFound, name)
+	}
+	if err := s.unlock(); err != nil {
+		return "", err
+	}
+	b, err := s.open(sealed, name)
+	if err != nil {
+		return "", fmt.Errorf("decrypt secret %q: %w", name, err)
+	}
+	return string(b), nil
+}
+
+// Set encrypts value under name and saves the store.
+func (s *Store) Set(name, value string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.unlock(); err != nil {
+		return err
+	}
+	sealed, err := s.seal([]byte(value), name)
+	if err != nil {
+		return err
+	}
+	s.file.Secrets[name] = sealed
+	return s.save()
+}
+
+// Remove deletes the secret name and saves the store. It reports whether
+// the secret existed.
+func (s *Store) Remove(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.file.Secrets[name]; !ok {
+		return false, nil
+	}
+	delete(s.file.Secrets, name)
+	return true, s.save()
+}
+
+// ValidateName checks that name can be referenced as secret:<name>.
+func ValidateName(name string) error {
+	if name == "" || strings.TrimSpace(name) != name || strings.ContainsAny(name, "\x00\r\n") {
+		return fmt.Errorf("invalid secret name %q", name)
+	}
+	return nil
+}
+
+// init sets up the key of a new store.
+func (s *Store) init() error {
+	s.file = storeFile{Version: 1, Secrets: map[string]string{}}
+	if s.opts.Passphrase != "" {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		s.file.KDF = KDFPassphrase
+		s.file.Salt = base64.StdEncoding.EncodeToString(salt)
+		s.file.Iterations = defaultIterations
+	} else {
+		s.file.KDF = KDFKeyFile
+	}
+	if err := s.deriveKey(true); err != nil {
+		return err
+	}
+	check, err := s.seal([]byte("stp"), checkName)
+	if err != nil {
+		return err
+	}
+	s.file.Check = check
+	return nil
+}
+
+// unlock derives and verifies the key of the store, setting up a new
+// store on first use.
+func (s *Store) unlock() error {
+	if s.key != nil {
+		return nil
+	}
+	if s.file.KDF == "" {
+		return s.init()
+	}
+	if err := s.deriveKey(false); err != nil {
+		return fmt.Errorf("secrets file %s: %w", s.path, err)
+	}
+	if _, err := s.open(s.file.Check, checkName); err != nil {
+		s.key = nil
+		if s.file.KDF == KDFPassphrase {
+			return fmt.Errorf("secrets file %s: wrong passphrase", s.path)
+		}
+		return fmt.Errorf("secrets file %s: key file does not match", s.path)
+	}
+	return nil
+}
+
+func (s *Store) deriveKey(create bool) error {
+	switch s.file.KDF {
+	case KDFPassphrase:
+		if s.opts.Passphrase == "" {
+			return fmt.Errorf("store is passphrase protected; set %s", PassphraseEnv)
+		}
+		salt, err := base64.StdEncoding.DecodeString(s.file.Salt)
+		if err != nil {
+			return fmt.Errorf("invalid salt: %w", err)
+		}
+		s.key, err = pbkdf2.Key(sha256.New, s.opts.Passphrase, salt, s.file.Iterations, 32)
+		return err
+	case KDFKeyFile:
+		key, err := loadKeyFile(s.opts.KeyFile, create)
+		if err != nil {
+			return err
+		}
+		s.key = key
+		return nil
+	}
+	return fmt.Errorf("unknown KDF %q", s.file.KDF)
+}
+
+// loadKeyFile reads the 32 byte key in path, generating it when create is
+// set and the file does not exist.
+func loadKeyFile(path string, create bool) ([]byte, error) {
+	if path == "" {
+		var err error
+		if path, err = DefaultKeyFile(); err != nil {
+			return nil, err
+		}
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && create {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key file: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("key file %s is not a base64 encoded 32 byte key", path)
+	}
+	return key, nil
+}
+
+func (s *Store) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns base64(nonce || ciphertext) of plain bound to name.
+func (s *Store) seal(plain []byte, name string) (string, error) {
+	gcm, err := s.aead()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	out := gcm.Seal(nonce, nonce, plain, []byte(name))
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+func (s *Store) open(sealed, name string) ([]byte, error) {
+	gcm, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(b) < gcm.NonceSize() {
+		return nil, errors.New("malformed value")
+	}
+	return gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], []byte(name))
+}
+
+// save writes the store through a temporary file (created with mode 0600)
+// so that a failed write never leaves a truncated store behind.
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.file, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".secrets-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,92 @@
+package secret
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStorePassphraseRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	s, err := Open(path, Options{Passphrase: "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("openai", "sk-plain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("org", "acme"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "sk-plain") || strings.Contains(string(data), "acme") {
+		t.Fatalf("secrets file contains plaintext: %s", data)
+	}
+
+	// Listing and removing need no key.
+	s, err = Open(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := s.Names(); len(names) != 2 || names[0] != "openai" {
+		t.Fatalf("unexpected names %v", names)
+	}
+	if _, err := s.Get("openai"); err == nil || !strings.Contains(err.Error(), PassphraseEnv) {
+		t.Fatalf("expected the missing passphrase to be reported, got %v", err)
+	}
+	if ok, err := s.Remove("org"); !ok || err != nil {
+		t.Fatalf("remove: %v %v", ok, err)
+	}
+
+	s, _ = Open(path, Options{Passphrase: "wrong"})
+	if _, err := s.Get("openai"); err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Fatalf("expected wrong passphrase, got %v", err)
+	}
+	s, _ = Open(path, Options{Passphrase: "pw"})
+	if v, err := s.Get("openai"); err != nil || v != "sk-plain" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+	if _, err := s.Get("org"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected removed secret to be gone, got %v", err)
+	}
+}
+
+func TestStoreKeyFileBindsNames(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secrets.json")
+	opts := Options{KeyFile: filepath.Join(dir, "key", "secret.key")}
+	s, _ := Open(path, opts)
+	if err := s.Set("a", "value-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("b", "value-b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(opts.KeyFile); err != nil {
+		t.Fatalf("key file not created: %v", err)
+	}
+
+	// A value copied to another name does not decrypt.
+	s, _ = Open(path, opts)
+	s.file.Secrets["b"] = s.file.Secrets["a"]
+	if _, err := s.Get("b"); err == nil {
+		t.Fatal("expected a swapped value to be rejected")
+	}
+	if v, err := s.Get("a"); err != nil || v != "value-a" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+
+	other := filepath.Join(dir, "other.key")
+	if err := os.WriteFile(other, []byte("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, _ = Open(path, Options{KeyFile: other})
+	if _, err := s.Get("a"); err == nil || !strings.Contains(err.Error(), "key file does not match") {
+		t.Fatalf("expected key mismatch, got %v", err)
+	}
+	if err := ValidateName(" spaced"); err == nil {
+		t.Fatal("expected invalid name to be rejected")
+	}
+}